@@ -0,0 +1,199 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBroadcastConcurrency 广播时默认同时发送的机器人数
+const DefaultBroadcastConcurrency = 4
+
+var ErrBotNotFound = errors.New("wxrobot: bot not found")
+
+// Sender 可发送的消息 所有To*Msg(...)返回的消息都实现了该接口
+type Sender interface {
+	// Validate 校验消息是否满足企业微信的要求
+	Validate() error
+	// SendContext 发送消息
+	SendContext(ctx context.Context) error
+	// SendAsync 异步发送消息
+	SendAsync() (*AsyncResult, error)
+	// withBot 复制一份由机器人r发送的消息
+	withBot(r *bot) Sender
+}
+
+// BroadcastResult 广播结果 key为机器人的名字 value为该机器人的发送结果 成功时为nil
+type BroadcastResult map[string]error
+
+// Failed 返回发送失败的机器人名字 按名字排序
+func (br BroadcastResult) Failed() []string {
+	var names []string
+	for name, err := range br {
+		if err != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Err 全部发送成功时返回nil 否则返回汇总了失败机器人的错误
+func (br BroadcastResult) Err() error {
+	failed := br.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failed))
+	for i, name := range failed {
+		msgs[i] = fmt.Sprintf("[%s] %v", name, br[name])
+	}
+	return fmt.Errorf("wxrobot: %d/%d个机器人发送失败: %s", len(failed), len(br), strings.Join(msgs, "; "))
+}
+
+// Broadcast 将同一条消息并发发送给多个机器人 names为通过Bot(name)注册的机器人名字
+// concurrency为同时发送的机器人数 小于等于0时为DefaultBroadcastConcurrency
+// 消息的chatid等设置对所有机器人生效；使用MediaId(...)发送文件时需确保media_id对所有机器人有效
+// 消息校验失败时不会发送 所有机器人的结果均为该错误；开启了Async(...)的机器人会等待消息实际发送完成后再记录结果
+func Broadcast(ctx context.Context, msg Sender, names []string, concurrency int) BroadcastResult {
+	result := make(BroadcastResult, len(names))
+	if err := prepareBroadcast(msg); err != nil {
+		for _, name := range names {
+			result[name] = err
+		}
+		return result
+	}
+
+	if concurrency <= 0 {
+		concurrency = DefaultBroadcastConcurrency
+	}
+
+	// 先确定要发送的机器人 再并发发送
+	bots := make(map[string]*bot, len(names))
+	for _, name := range names {
+		v, ok := wxRobot.robots.Load(name)
+		if !ok {
+			result[name] = fmt.Errorf("%w: %s", ErrBotNotFound, name)
+			continue
+		}
+		result[name] = nil
+		bots[name] = v.(*bot)
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for name, r := range bots {
+		wg.Add(1)
+		go func(name string, r *bot) {
+			defer wg.Done()
+
+			var err error
+			select {
+			case sem <- struct{}{}:
+				err = broadcastSend(ctx, msg.withBot(r), r)
+				<-sem
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				wxRobot.logger.Error(fmt.Sprintf("机器人[%s]广播消息失败: %v", name, err))
+			}
+
+			mu.Lock()
+			result[name] = err
+			mu.Unlock()
+		}(name, r)
+	}
+	wg.Wait()
+	return result
+}
+
+// broadcastSend 发送消息并返回实际的发送结果 异步模式下Send只返回入队结果 因此等待队列发送完成
+func broadcastSend(ctx context.Context, msg Sender, r *bot) error {
+	if r.currentQueue() == nil {
+		return msg.SendContext(ctx)
+	}
+	res, err := msg.SendAsync()
+	if errors.Is(err, ErrAsyncDisabled) {
+		// 期间关闭了异步模式
+		return msg.SendContext(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return res.Wait(ctx)
+}
+
+// prepareBroadcast 发送前统一校验消息 图片只转换一次 reader中的文件读入内存以便多次上传
+func prepareBroadcast(msg Sender) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if tm, ok := msg.(*toMsgFile); ok && tm.fileReader != nil {
+		bts, err := ioutil.ReadAll(tm.fileReader)
+		if err != nil {
+			return err
+		}
+		if err = checkMediaSize(tm.fileName, int64(len(bts)), MediaTypeFile); err != nil {
+			return err
+		}
+		tm.fileReader = nil
+		tm.fileContent = bts
+	}
+	return nil
+}
+
+func (t *toMsgText) withBot(r *bot) Sender {
+	msg := *t
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgMarkdown) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgMarkdownV2) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgNews) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgImage) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgFile) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgVoice) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
+
+func (tm *toMsgTemplateCard) withBot(r *bot) Sender {
+	msg := *tm
+	msg.bot = r
+	return &msg
+}
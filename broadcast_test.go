@@ -0,0 +1,55 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errcode":0}`))
+	}))
+	defer ok.Close()
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errcode":93000,"errmsg":"invalid webhook url"}`))
+	}))
+	defer invalid.Close()
+
+	Bot("broadcast-ok").WebhookURL(ok.URL + "/send?key=ok")
+	Bot("broadcast-invalid").WebhookURL(invalid.URL + "/send?key=invalid")
+	// 异步模式下Send只返回入队结果 广播需等待实际的发送结果
+	Bot("broadcast-async-ok").WebhookURL(ok.URL + "/send?key=async-ok").Async(AsyncConfig{Workers: 1})
+	Bot("broadcast-async-invalid").WebhookURL(invalid.URL + "/send?key=async-invalid").Async(AsyncConfig{Workers: 1})
+	defer func() {
+		_ = Bot("broadcast-async-ok").StopAsync(context.Background())
+		_ = Bot("broadcast-async-invalid").StopAsync(context.Background())
+	}()
+
+	names := []string{"broadcast-ok", "broadcast-invalid", "broadcast-async-ok", "broadcast-async-invalid", "broadcast-missing"}
+	res := Broadcast(context.Background(), Bot("broadcast-ok").ToTextMsg("release v1.2"), names, 2)
+	want := map[string]error{
+		"broadcast-ok":            nil,
+		"broadcast-invalid":       ErrInvalidWebhookKey,
+		"broadcast-async-ok":      nil,
+		"broadcast-async-invalid": ErrInvalidWebhookKey,
+		"broadcast-missing":       ErrBotNotFound,
+	}
+	for name, wantErr := range want {
+		if err := res[name]; !errors.Is(err, wantErr) || (wantErr == nil && err != nil) {
+			t.Errorf("%s: err = %v, want %v", name, err, wantErr)
+		}
+	}
+	if failed := res.Failed(); len(failed) != 3 || res.Err() == nil {
+		t.Errorf("Failed() = %v, Err() = %v", failed, res.Err())
+	}
+
+	res = Broadcast(context.Background(), Bot("broadcast-ok").ToTextMsg(""), names[:2], 0)
+	for name, err := range res {
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: invalid message err = %v", name, err)
+		}
+	}
+}
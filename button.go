@@ -0,0 +1,197 @@
+package wxrobot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 按钮各字段的长度限制(字节)
+const (
+	MaxButtonNameBytes        = 64
+	MaxButtonTextBytes        = 128
+	MaxButtonValueBytes       = 128
+	MaxButtonReplaceTextBytes = 128
+)
+
+// button markdown消息中的按钮
+type button struct {
+	action MsgAction
+}
+
+// NewButton 新建按钮 name为按钮的名字 用于OnButton(...)路由；text为按钮上展示的文案
+func NewButton(name, text string) *button {
+	return &button{action: MsgAction{Name: name, Text: text, Type: "button"}}
+}
+
+// Value 点击按钮时回调的值
+func (b *button) Value(value string) *button {
+	b.action.Value = value
+	return b
+}
+
+// BorderColor 按钮边框颜色 16进制颜色如2EAB49
+func (b *button) BorderColor(color string) *button {
+	b.action.BorderColor = strings.TrimPrefix(color, "#")
+	return b
+}
+
+// TextColor 按钮文字颜色 16进制颜色如2EAB49
+func (b *button) TextColor(color string) *button {
+	b.action.TextColor = strings.TrimPrefix(color, "#")
+	return b
+}
+
+// ReplaceText 点击按钮后按钮替换为的文本
+func (b *button) ReplaceText(text string) *button {
+	b.action.ReplaceText = text
+	return b
+}
+
+// Validate 校验按钮的颜色及各字段长度
+func (b *button) Validate() error {
+	return checkAction("button", b.action)
+}
+
+// Action 返回按钮对应的MsgAction
+func (b *button) Action() MsgAction {
+	return b.action
+}
+
+// checkAction 校验attachment中的按钮
+func checkAction(field string, action MsgAction) error {
+	if action.Type != "button" {
+		return invalidf("%s.type目前仅支持button, 当前为[%s]", field, action.Type)
+	}
+	if action.Name == "" {
+		return invalidf("%s.name不能为空", field)
+	}
+	if action.Text == "" {
+		return invalidf("%s.text不能为空", field)
+	}
+	if action.BorderColor != "" && !hexColorPattern.MatchString(action.BorderColor) {
+		return invalidf("%s.border_color应为16进制颜色如2EAB49, 当前为[%s]", field, action.BorderColor)
+	}
+	if action.TextColor != "" && !hexColorPattern.MatchString(action.TextColor) {
+		return invalidf("%s.text_color应为16进制颜色如2EAB49, 当前为[%s]", field, action.TextColor)
+	}
+
+	limits := []struct {
+		name  string
+		value string
+		limit int
+	}{
+		{"name", action.Name, MaxButtonNameBytes},
+		{"text", action.Text, MaxButtonTextBytes},
+		{"value", action.Value, MaxButtonValueBytes},
+		{"replace_text", action.ReplaceText, MaxButtonReplaceTextBytes},
+	}
+	for _, l := range limits {
+		if len(l.value) > l.limit {
+			return invalidf("%s.%s不能超过%d字节, 当前为%d字节", field, l.name, l.limit, len(l.value))
+		}
+	}
+	return nil
+}
+
+// Buttons 添加一组按钮 callbackID用于OnButton(...)路由点击事件
+func (tm *toMsgMarkdown) Buttons(callbackID string, buttons ...*button) *toMsgMarkdown {
+	attach := &MsgAttachment{CallbackID: callbackID}
+	for _, b := range buttons {
+		attach.Actions = append(attach.Actions, b.action)
+	}
+	return tm.Attachment(attach)
+}
+
+type buttonHandler func(msg *FromAttachmentMsg, action MsgAction)
+
+type buttonRoute struct {
+	callbackID string
+	actionName string
+	handler    buttonHandler
+}
+
+// buttonRouter 按CallbackID及按钮名字分发点击事件
+type buttonRouter struct {
+	mu     sync.RWMutex
+	routes []*buttonRoute
+}
+
+// OnButton 注册按钮点击事件的处理函数
+// callbackID和actionName支持*通配符，如OnButton("deploy-*", "*", handler)；
+// 多个注册都匹配时，精确匹配优先于通配符，同等情况下先注册的优先；没有匹配的注册时交给RegisterHandlerForAttachment注册的函数处理
+func (r *bot) OnButton(callbackID, actionName string, handler buttonHandler) *bot {
+	r.buttons.mu.Lock()
+	defer r.buttons.mu.Unlock()
+
+	r.buttons.routes = append(r.buttons.routes, &buttonRoute{callbackID: callbackID, actionName: actionName, handler: handler})
+	return r
+}
+
+// match 返回匹配的处理函数
+func (br *buttonRouter) match(callbackID, actionName string) buttonHandler {
+	br.mu.RLock()
+	defer br.mu.RUnlock()
+
+	var (
+		best      buttonHandler
+		bestScore = -1
+	)
+	for _, route := range br.routes {
+		if !wildcardMatch(route.callbackID, callbackID) || !wildcardMatch(route.actionName, actionName) {
+			continue
+		}
+		score := 0
+		if !strings.Contains(route.callbackID, "*") {
+			score += 2
+		}
+		if !strings.Contains(route.actionName, "*") {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = route.handler, score
+		}
+	}
+	return best
+}
+
+// dispatch 将点击事件分发给匹配的处理函数 没有匹配时返回false
+func (br *buttonRouter) dispatch(msg *FromAttachmentMsg) bool {
+	var handled bool
+	for _, action := range msg.Attachment.Actions {
+		handler := br.match(msg.Attachment.CallbackID, action.Name)
+		if handler == nil {
+			continue
+		}
+		handled = true
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					wxRobot.logger.Error(fmt.Sprintf("按钮[%s/%s]处理失败: %v", msg.Attachment.CallbackID, action.Name, err))
+				}
+			}()
+			handler(msg, action)
+		}()
+	}
+	return handled
+}
+
+// wildcardMatch 匹配含*通配符的模式 *可匹配任意长度的字符串
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
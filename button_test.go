@@ -0,0 +1,111 @@
+package wxrobot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"deploy", "deploy", true},
+		{"deploy", "deploy-1", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"deploy-*", "deploy-42", true},
+		{"deploy-*", "deploy-", true},
+		{"deploy-*", "rollback-42", false},
+		{"*-prod", "api-prod", true},
+		{"*-prod", "api-test", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"a*a", "a", false},
+		{"a**", "abc", true},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestButtonValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		button  *button
+		wantErr string
+	}{
+		{"ok", NewButton("approve", "批准").Value("1").BorderColor("#2EAB49").TextColor("2eab49"), ""},
+		{"empty name", NewButton("", "批准"), "name不能为空"},
+		{"empty text", NewButton("approve", ""), "text不能为空"},
+		{"bad color", NewButton("approve", "批准").BorderColor("green"), "border_color"},
+		{"bad text color", NewButton("approve", "批准").TextColor("#12345"), "text_color"},
+		{"long name", NewButton(strings.Repeat("n", MaxButtonNameBytes+1), "批准"), "name不能超过"},
+		{"long value", NewButton("approve", "批准").Value(strings.Repeat("v", MaxButtonValueBytes+1)), "value不能超过"},
+		{"long replace text", NewButton("approve", "批准").ReplaceText(strings.Repeat("已", MaxButtonReplaceTextBytes)), "replace_text不能超过"},
+	}
+	for _, tt := range tests {
+		err := tt.button.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if color := NewButton("a", "b").BorderColor("#2EAB49").Action().BorderColor; color != "2EAB49" {
+		t.Errorf("BorderColor should strip #, got %q", color)
+	}
+}
+
+func TestButtonRouter(t *testing.T) {
+	b := Bot("button-test")
+	var got []string
+	handler := func(name string) buttonHandler {
+		return func(msg *FromAttachmentMsg, action MsgAction) {
+			got = append(got, name+":"+action.Name)
+		}
+	}
+	// 精确匹配优先于通配符 同等情况下先注册的优先
+	b.OnButton("deploy-*", "*", handler("deploy-any")).
+		OnButton("*", "*", handler("any")).
+		OnButton("deploy-*", "approve", handler("deploy-approve")).
+		OnButton("*", "approve", handler("any-approve")).
+		OnButton("deploy-*", "approve", handler("deploy-approve-later")).
+		OnButton("deploy-1", "*", handler("deploy1-any")).
+		OnButton("panic", "*", func(*FromAttachmentMsg, MsgAction) { panic("boom") })
+
+	tests := []struct {
+		callbackID string
+		actions    []string
+		want       []string
+	}{
+		{"deploy-1", []string{"approve"}, []string{"deploy1-any:approve"}},
+		{"deploy-2", []string{"approve", "reject"}, []string{"deploy-approve:approve", "deploy-any:reject"}},
+		{"other", []string{"approve"}, []string{"any-approve:approve"}},
+		{"other", []string{"reject"}, []string{"any:reject"}},
+		{"panic", []string{"x"}, nil},
+	}
+	for _, tt := range tests {
+		got = nil
+		msg := &FromAttachmentMsg{}
+		msg.Attachment.CallbackID = tt.callbackID
+		for _, name := range tt.actions {
+			msg.Attachment.Actions = append(msg.Attachment.Actions, MsgAction{Name: name, Type: "button"})
+		}
+		if !b.buttons.dispatch(msg) {
+			t.Errorf("%s: dispatch returned false", tt.callbackID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s %v: handled by %v, want %v", tt.callbackID, tt.actions, got, tt.want)
+		}
+	}
+
+	empty := Bot("button-test-empty")
+	if empty.buttons.dispatch(&FromAttachmentMsg{Attachment: MsgAttachment{Actions: []MsgAction{{Name: "x"}}}}) {
+		t.Error("dispatch without routes should return false")
+	}
+}
@@ -0,0 +1,482 @@
+package wxrobot
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// CommandPrefix 命令的前缀 如/deploy
+const CommandPrefix = "/"
+
+// helpCommand 自动生成的帮助命令 注册了同名命令时以注册的为准
+const helpCommand = "help"
+
+type commandHandler func(msg *FromTextMsg, args *CommandArgs)
+
+// commandFlag 命令的参数定义
+type commandFlag struct {
+	name  string
+	value interface{} // 默认值 类型决定参数的类型
+	usage string
+}
+
+// command 文本命令 由bot.Command(...)创建
+type command struct {
+	name        string
+	aliases     []string
+	description string
+	usage       string
+	args        []string // 必填的位置参数
+	flags       []commandFlag
+	handler     commandHandler
+}
+
+// Alias 命令的别名 如/deploy的别名/d
+func (c *command) Alias(aliases ...string) *command {
+	for _, alias := range aliases {
+		c.aliases = append(c.aliases, strings.ToLower(strings.TrimPrefix(alias, CommandPrefix)))
+	}
+	return c
+}
+
+// Description 命令的说明 展示在/help中
+func (c *command) Description(desc string) *command {
+	c.description = desc
+	return c
+}
+
+// Usage 命令的用法 为空时根据参数自动生成
+func (c *command) Usage(usage string) *command {
+	c.usage = usage
+	return c
+}
+
+// Args 必填的位置参数 缺少时回复用法 处理函数中通过args.Arg(name)读取
+func (c *command) Args(names ...string) *command {
+	c.args = append(c.args, names...)
+	return c
+}
+
+// StringFlag 字符串参数 如--env prod或--env=prod
+func (c *command) StringFlag(name, value, usage string) *command {
+	c.flags = append(c.flags, commandFlag{name: name, value: value, usage: usage})
+	return c
+}
+
+// IntFlag 整数参数
+func (c *command) IntFlag(name string, value int, usage string) *command {
+	c.flags = append(c.flags, commandFlag{name: name, value: value, usage: usage})
+	return c
+}
+
+// BoolFlag 开关参数 如--force
+func (c *command) BoolFlag(name string, value bool, usage string) *command {
+	c.flags = append(c.flags, commandFlag{name: name, value: value, usage: usage})
+	return c
+}
+
+// DurationFlag 时长参数 如--timeout 30s
+func (c *command) DurationFlag(name string, value time.Duration, usage string) *command {
+	c.flags = append(c.flags, commandFlag{name: name, value: value, usage: usage})
+	return c
+}
+
+// usageText 命令的用法 含参数说明
+func (c *command) usageText() string {
+	var sb strings.Builder
+	if c.usage != "" {
+		sb.WriteString(c.usage)
+	} else {
+		sb.WriteString(CommandPrefix + c.name)
+		for _, arg := range c.args {
+			sb.WriteString(" <" + arg + ">")
+		}
+		for _, f := range c.flags {
+			switch f.value.(type) {
+			case bool:
+				sb.WriteString(" [--" + f.name + "]")
+			case time.Duration:
+				sb.WriteString(" [--" + f.name + " duration]")
+			default:
+				sb.WriteString(fmt.Sprintf(" [--%s %T]", f.name, f.value))
+			}
+		}
+	}
+	if c.description != "" {
+		sb.WriteString("\n" + c.description)
+	}
+	if len(c.aliases) > 0 {
+		sb.WriteString("\n别名: " + CommandPrefix + strings.Join(c.aliases, ", "+CommandPrefix))
+	}
+	for _, f := range c.flags {
+		sb.WriteString(fmt.Sprintf("\n  --%s  %s (默认: %v)", f.name, f.usage, f.value))
+	}
+	return sb.String()
+}
+
+// parse 解析参数 参数与位置参数可以交替出现 --之后的都作为位置参数
+func (c *command) parse(name string, tokens []string) (*CommandArgs, error) {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() {}
+
+	args := &CommandArgs{name: name, flags: make(map[string]interface{}, len(c.flags)), named: make(map[string]string)}
+	for _, f := range c.flags {
+		switch v := f.value.(type) {
+		case string:
+			args.flags[f.name] = fs.String(f.name, v, f.usage)
+		case int:
+			args.flags[f.name] = fs.Int(f.name, v, f.usage)
+		case bool:
+			args.flags[f.name] = fs.Bool(f.name, v, f.usage)
+		case time.Duration:
+			args.flags[f.name] = fs.Duration(f.name, v, f.usage)
+		}
+	}
+
+	rest := tokens
+	for len(rest) > 0 {
+		if err := fs.Parse(rest); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, fmt.Errorf("未定义的参数 -h")
+			}
+			return nil, err
+		}
+		remain := fs.Args()
+		if consumed := len(rest) - len(remain); consumed > 0 && rest[consumed-1] == "--" {
+			args.args = append(args.args, remain...)
+			break
+		}
+		if len(remain) == 0 {
+			break
+		}
+		args.args = append(args.args, remain[0])
+		rest = remain[1:]
+	}
+
+	if len(args.args) < len(c.args) {
+		return nil, fmt.Errorf("缺少参数<%s>", c.args[len(args.args)])
+	}
+	for i, arg := range c.args {
+		args.named[arg] = args.args[i]
+	}
+	return args, nil
+}
+
+// CommandArgs 解析后的命令参数
+type CommandArgs struct {
+	name  string
+	args  []string
+	named map[string]string
+	flags map[string]interface{}
+}
+
+// Name 命令的名字 通过别名调用时为命令的名字而非别名
+func (a *CommandArgs) Name() string {
+	return a.name
+}
+
+// Args 全部位置参数
+func (a *CommandArgs) Args() []string {
+	return a.args
+}
+
+// Arg 通过command.Args(...)定义的位置参数
+func (a *CommandArgs) Arg(name string) string {
+	return a.named[name]
+}
+
+// String 字符串参数的值 未定义时返回空字符串
+func (a *CommandArgs) String(name string) string {
+	if v, ok := a.flags[name].(*string); ok {
+		return *v
+	}
+	return ""
+}
+
+// Int 整数参数的值 未定义时返回0
+func (a *CommandArgs) Int(name string) int {
+	if v, ok := a.flags[name].(*int); ok {
+		return *v
+	}
+	return 0
+}
+
+// Bool 开关参数的值 未定义时返回false
+func (a *CommandArgs) Bool(name string) bool {
+	if v, ok := a.flags[name].(*bool); ok {
+		return *v
+	}
+	return false
+}
+
+// Duration 时长参数的值 未定义时返回0
+func (a *CommandArgs) Duration(name string) time.Duration {
+	if v, ok := a.flags[name].(*time.Duration); ok {
+		return *v
+	}
+	return 0
+}
+
+// commandRouter 按命令名字分发文本消息
+type commandRouter struct {
+	mu       sync.RWMutex
+	commands []*command
+	mentions []string // 群聊中@机器人时显示的名字
+}
+
+// CommandMention 设置群聊中@机器人时显示的名字 名字中含有空格时需设置，否则只去掉消息开头不含空格的@名字
+func (r *bot) CommandMention(names ...string) *bot {
+	r.commands.mu.Lock()
+	defer r.commands.mu.Unlock()
+
+	for _, name := range names {
+		r.commands.mentions = append(r.commands.mentions, strings.TrimPrefix(name, "@"))
+	}
+	return r
+}
+
+// Command 注册文本命令 如Command("deploy", handler)处理"/deploy api --env prod"
+// 群聊中消息开头的@机器人会被去掉；注册了命令后自动支持/help，未知命令会回复相近的命令；
+// 不以/开头的消息仍交给RegisterHandlerForText注册的函数处理
+func (r *bot) Command(name string, handler commandHandler) *command {
+	r.commands.mu.Lock()
+	defer r.commands.mu.Unlock()
+
+	c := &command{name: strings.ToLower(strings.TrimPrefix(name, CommandPrefix)), handler: handler}
+	r.commands.commands = append(r.commands.commands, c)
+	return c
+}
+
+// lookup 按名字或别名查找命令 后注册的优先
+func (cr *commandRouter) lookup(name string) *command {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	for i := len(cr.commands) - 1; i >= 0; i-- {
+		c := cr.commands[i]
+		if c.name == name {
+			return c
+		}
+		for _, alias := range c.aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// dispatch 处理命令消息 消息不是命令或没有注册命令时返回false
+func (cr *commandRouter) dispatch(msg *FromTextMsg) bool {
+	cr.mu.RLock()
+	empty, mentions := len(cr.commands) == 0, cr.mentions
+	cr.mu.RUnlock()
+	if empty {
+		return false
+	}
+
+	content := stripMention(msg.Text.Content, mentions)
+	if !strings.HasPrefix(content, CommandPrefix) {
+		return false
+	}
+	tokens, err := splitCommand(strings.TrimPrefix(content, CommandPrefix))
+	if err != nil {
+		cr.reply(msg, err.Error())
+		return true
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+
+	name := strings.ToLower(tokens[0])
+	c := cr.lookup(name)
+	if c == nil {
+		if name == helpCommand {
+			cr.reply(msg, cr.help(tokens[1:]))
+			return true
+		}
+		reply := fmt.Sprintf("未知命令%s%s", CommandPrefix, name)
+		if suggestion := cr.suggest(name); suggestion != "" {
+			reply += fmt.Sprintf(", 是否要输入%s%s?", CommandPrefix, suggestion)
+		}
+		cr.reply(msg, reply+"\n输入"+CommandPrefix+helpCommand+"查看全部命令")
+		return true
+	}
+
+	args, err := c.parse(c.name, tokens[1:])
+	if err != nil {
+		cr.reply(msg, fmt.Sprintf("参数错误: %v\n用法: %s", err, c.usageText()))
+		return true
+	}
+
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				wxRobot.logger.Error(fmt.Sprintf("命令[%s]处理失败: %v", c.name, err))
+			}
+		}()
+		c.handler(msg, args)
+	}()
+	return true
+}
+
+func (cr *commandRouter) reply(msg *FromTextMsg, content string) {
+	if err := msg.ToTextMsg(content).Send(); err != nil {
+		wxRobot.logger.Error("回复命令消息失败:", err)
+	}
+}
+
+// help /help列出全部命令 /help deploy展示命令的用法
+func (cr *commandRouter) help(tokens []string) string {
+	if len(tokens) > 0 {
+		name := strings.ToLower(strings.TrimPrefix(tokens[0], CommandPrefix))
+		if c := cr.lookup(name); c != nil {
+			return c.usageText()
+		}
+		return fmt.Sprintf("未知命令%s%s", CommandPrefix, name)
+	}
+
+	cr.mu.RLock()
+	commands := make(map[string]*command, len(cr.commands))
+	for _, c := range cr.commands {
+		commands[c.name] = c
+	}
+	cr.mu.RUnlock()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("可用命令:")
+	for _, name := range names {
+		sb.WriteString("\n" + CommandPrefix + name)
+		if desc := commands[name].description; desc != "" {
+			sb.WriteString("  " + desc)
+		}
+	}
+	sb.WriteString("\n输入" + CommandPrefix + helpCommand + " <命令>查看用法")
+	return sb.String()
+}
+
+// suggest 返回与name最相近的命令 编辑距离过大时返回空字符串
+func (cr *commandRouter) suggest(name string) string {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	best, bestDist := "", len([]rune(name))/2+1
+	for _, c := range cr.commands {
+		for _, candidate := range append([]string{c.name}, c.aliases...) {
+			if d := editDistance(name, candidate); d < bestDist {
+				best, bestDist = c.name, d
+			}
+		}
+	}
+	return best
+}
+
+// editDistance 两个字符串的编辑距离
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(v int, vs ...int) int {
+	for _, n := range vs {
+		if n < v {
+			v = n
+		}
+	}
+	return v
+}
+
+// stripMention 去掉群聊消息开头的@机器人 只有紧跟在@之后的内容才可能是命令 如"@Deploy Bot /deploy api"
+// 名字为names之一时整体去掉(名字可含空格)，否则去掉开头到第一个空白处的@名字
+func stripMention(content string, names []string) string {
+	content = strings.TrimSpace(content)
+	for strings.HasPrefix(content, "@") {
+		content = strings.TrimSpace(content[mentionLen(content, names):])
+	}
+	return content
+}
+
+// mentionLen 返回content开头的@名字的长度
+func mentionLen(content string, names []string) int {
+	for _, name := range names {
+		mention := "@" + name
+		if !strings.HasPrefix(content, mention) {
+			continue
+		}
+		if rest := content[len(mention):]; rest == "" || unicode.IsSpace([]rune(rest)[0]) {
+			return len(mention)
+		}
+	}
+	if i := strings.IndexFunc(content, unicode.IsSpace); i >= 0 {
+		return i
+	}
+	return len(content)
+}
+
+// splitCommand 按空白拆分命令 支持单引号及双引号包含空白的参数
+func splitCommand(content string) ([]string, error) {
+	var (
+		tokens  []string
+		sb      strings.Builder
+		quote   rune
+		inToken bool
+	)
+	for _, c := range content {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				sb.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inToken = c, true
+		case unicode.IsSpace(c):
+			if inToken {
+				tokens = append(tokens, sb.String())
+				sb.Reset()
+				inToken = false
+			}
+		default:
+			sb.WriteRune(c)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("引号%c未闭合", quote)
+	}
+	if inToken {
+		tokens = append(tokens, sb.String())
+	}
+	return tokens, nil
+}
@@ -0,0 +1,204 @@
+package wxrobot
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{"deploy api", []string{"deploy", "api"}, false},
+		{"  deploy \t api  ", []string{"deploy", "api"}, false},
+		{`echo "hello world" 'a b'`, []string{"echo", "hello world", "a b"}, false},
+		{`echo --msg="a b"c`, []string{"echo", "--msg=a bc"}, false},
+		{`echo "" x`, []string{"echo", "", "x"}, false},
+		{`echo "it's"`, []string{"echo", "it's"}, false},
+		{"", nil, false},
+		{`echo "unclosed`, nil, true},
+		{`echo 'unclosed`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.content)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitCommand(%q) err = %v, wantErr %v", tt.content, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestStripMention(t *testing.T) {
+	names := []string{"Deploy Bot"}
+	tests := []struct {
+		content, want string
+	}{
+		{"/deploy api", "/deploy api"},
+		{"  /deploy api ", "/deploy api"},
+		{"@DeployBot /deploy api", "/deploy api"},
+		{"@DeployBot", ""},
+		{"@DeployBot @zhangsan /deploy api", "/deploy api"},
+		{"@Deploy Bot /deploy api", "/deploy api"},
+		{"@Deploy Bot\n/deploy api", "/deploy api"},
+		{"@Deploy Bots /deploy api", "Bots /deploy api"},
+		{"@Deploy/Bot /deploy a/b", "/deploy a/b"},
+		{"@DeployBot / deploy", "/ deploy"},
+		{"@DeployBot hello", "hello"},
+		{"@bot why is /var full", "why is /var full"},
+		{"hello /deploy", "hello /deploy"},
+	}
+	for _, tt := range tests {
+		if got := stripMention(tt.content, names); got != tt.want {
+			t.Errorf("stripMention(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+	// 未设置名字时只去掉不含空格的@名字
+	if got := stripMention("@Deploy Bot /deploy api", nil); got != "Bot /deploy api" {
+		t.Errorf("stripMention without names = %q", got)
+	}
+}
+
+func TestCommandParse(t *testing.T) {
+	c := (&command{name: "deploy"}).Args("service").
+		StringFlag("env", "test", "环境").
+		IntFlag("replicas", 1, "副本数").
+		BoolFlag("force", false, "强制发布").
+		DurationFlag("timeout", time.Minute, "超时时间")
+
+	tests := []struct {
+		name     string
+		tokens   []string
+		wantArgs []string
+		wantErr  string
+		check    func(a *CommandArgs) bool
+	}{
+		{"defaults", []string{"api"}, []string{"api"}, "", func(a *CommandArgs) bool {
+			return a.String("env") == "test" && a.Int("replicas") == 1 && !a.Bool("force") && a.Duration("timeout") == time.Minute
+		}},
+		{"flags after args", []string{"api", "--env", "prod", "--force", "--timeout=30s"}, []string{"api"}, "", func(a *CommandArgs) bool {
+			return a.String("env") == "prod" && a.Bool("force") && a.Duration("timeout") == 30*time.Second
+		}},
+		{"interleaved", []string{"--replicas", "3", "api", "extra", "--env=prod"}, []string{"api", "extra"}, "", func(a *CommandArgs) bool {
+			return a.Int("replicas") == 3 && a.String("env") == "prod"
+		}},
+		{"double dash", []string{"api", "--", "--force", "x"}, []string{"api", "--force", "x"}, "", func(a *CommandArgs) bool {
+			return !a.Bool("force")
+		}},
+		{"undefined getters", []string{"api"}, []string{"api"}, "", func(a *CommandArgs) bool {
+			return a.String("missing") == "" && a.Int("env") == 0 && !a.Bool("env") && a.Duration("env") == 0
+		}},
+		{"missing arg", []string{"--force"}, nil, "缺少参数<service>", nil},
+		{"unknown flag", []string{"api", "--region", "sh"}, nil, "region", nil},
+		{"bad int", []string{"api", "--replicas", "many"}, nil, "replicas", nil},
+		{"help flag", []string{"-h"}, nil, "-h", nil},
+	}
+	for _, tt := range tests {
+		args, err := c.parse("d", tt.tokens)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if args.Name() != "d" || args.Arg("service") != tt.wantArgs[0] || !reflect.DeepEqual(args.Args(), tt.wantArgs) {
+			t.Errorf("%s: name = %q, args = %q, want %q", tt.name, args.Name(), args.Args(), tt.wantArgs)
+		}
+		if !tt.check(args) {
+			t.Errorf("%s: unexpected flag values", tt.name)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"deploy", "deploy", 0},
+		{"deploy", "deplyo", 2},
+		{"deploy", "deploys", 1},
+		{"", "abc", 3},
+		{"发布", "发", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCommandDispatch(t *testing.T) {
+	var replies []string
+	b := Bot("command-test").WebhookURL("http://127.0.0.1/send?key=test").
+		SwitchDryRunMode(true, func(payload []byte) {
+			var msg struct {
+				Text struct {
+					Content string `json:"content"`
+				} `json:"text"`
+			}
+			_ = json.Unmarshal(payload, &msg)
+			replies = append(replies, msg.Text.Content)
+		})
+
+	var handled []string
+	b.CommandMention("@Deploy Bot")
+	b.Command("deploy", func(msg *FromTextMsg, args *CommandArgs) {
+		handled = append(handled, args.Name()+" "+args.Arg("service")+" "+args.String("env"))
+	}).Alias("/d").Args("service").StringFlag("env", "test", "环境").Description("发布服务")
+	b.Command("panic", func(*FromTextMsg, *CommandArgs) { panic("boom") })
+
+	tests := []struct {
+		content     string
+		wantHandled bool
+		wantRun     string
+		wantReply   string
+	}{
+		{"/deploy api --env prod", true, "deploy api prod", ""},
+		{"@Deploy Bot /D api", true, "deploy api test", ""},
+		{"/deploy", true, "", "缺少参数<service>"},
+		{`/deploy "api`, true, "", "未闭合"},
+		{"/deplyo api", true, "", "是否要输入/deploy?"},
+		{"/rollback", true, "", "未知命令/rollback\n"},
+		{"/help", true, "", "/deploy  发布服务"},
+		{"/help d", true, "", "别名: /d"},
+		{"/panic", true, "", ""},
+		{"hello", false, "", ""},
+		{"@Deploy Bot why is /var full", false, "", ""},
+		{"/", false, "", ""},
+	}
+	for _, tt := range tests {
+		replies, handled = nil, nil
+		msg := &FromTextMsg{}
+		msg.bot = b
+		msg.Text.Content = tt.content
+		if got := b.commands.dispatch(msg); got != tt.wantHandled {
+			t.Errorf("%q: dispatch = %v, want %v", tt.content, got, tt.wantHandled)
+		}
+		if got := strings.Join(handled, ","); got != tt.wantRun {
+			t.Errorf("%q: handled %q, want %q", tt.content, got, tt.wantRun)
+		}
+		switch {
+		case tt.wantReply == "" && len(replies) > 0:
+			t.Errorf("%q: unexpected reply %q", tt.content, replies)
+		case tt.wantReply != "" && (len(replies) != 1 || !strings.Contains(replies[0], tt.wantReply)):
+			t.Errorf("%q: replies %q, want containing %q", tt.content, replies, tt.wantReply)
+		}
+	}
+
+	if Bot("command-test-empty").commands.dispatch(&FromTextMsg{Text: fromText{Content: "/deploy"}}) {
+		t.Error("dispatch without commands should return false")
+	}
+}
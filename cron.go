@@ -0,0 +1,262 @@
+package wxrobot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule 定时任务的执行时间表
+type cronSchedule interface {
+	// Next 返回t之后的下一次执行时间 不存在时返回零值
+	Next(t time.Time) time.Time
+}
+
+// cronDescriptors 预定义的表达式
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDowNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronField 表达式中每个字段的取值范围
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = [5]cronField{
+	{name: "分钟", min: 0, max: 59},
+	{name: "小时", min: 0, max: 23},
+	{name: "日", min: 1, max: 31},
+	{name: "月", min: 1, max: 12, names: cronMonthNames},
+	{name: "星期", min: 0, max: 7, names: cronDowNames},
+}
+
+// parseCron 解析定时任务表达式
+// 支持标准的5段表达式: 分钟 小时 日 月 星期
+// 每段支持*、数字、a-b范围、*/n或a-b/n步长及逗号分隔的列表，月和星期支持JAN、MON等英文缩写，星期的0和7均表示周日
+// 支持@yearly、@monthly、@weekly、@daily、@hourly及@every 1h30m形式的固定间隔
+// 可以CRON_TZ=Asia/Shanghai或TZ=Asia/Shanghai开头指定时区 默认为本地时区
+func parseCron(spec string) (cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	loc := time.Local
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("wxrobot: 定时表达式[%s]缺少时间字段", spec)
+		}
+		var err error
+		tz := spec[strings.Index(spec, "=")+1 : i]
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("wxrobot: 定时表达式时区[%s]无效: %w", tz, err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("wxrobot: 定时表达式[%s]的间隔无效: %w", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("wxrobot: 定时表达式[%s]的间隔不能小于1s", spec)
+		}
+		return everySchedule(d.Round(time.Second)), nil
+	}
+	if s, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("wxrobot: 定时表达式[%s]应为5段: 分钟 小时 日 月 星期", spec)
+	}
+
+	s := &cronSpec{loc: loc}
+	bits := [5]*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, expr := range fields {
+		b, star, err := parseCronField(expr, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("wxrobot: 定时表达式[%s]的%s字段无效: %w", spec, cronFields[i].name, err)
+		}
+		*bits[i] = b
+		switch i {
+		case 2:
+			s.domStar = star
+		case 4:
+			s.dowStar = star
+		}
+	}
+	// 星期的7与0均为周日
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField 解析表达式中的一段 返回取值的位图 star表示该段为*
+func parseCronField(expr string, f cronField) (bits uint64, star bool, err error) {
+	for _, item := range strings.Split(expr, ",") {
+		rangeExpr, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			rangeExpr = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("步长[%s]无效", item[i+1:])
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			lo, hi = f.min, f.max
+			star = star || (step == 1 && len(expr) == len(item))
+		case strings.Contains(rangeExpr, "-"):
+			parts := strings.SplitN(rangeExpr, "-", 2)
+			if lo, err = f.value(parts[0]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = f.value(parts[1]); err != nil {
+				return 0, false, err
+			}
+		default:
+			if lo, err = f.value(rangeExpr); err != nil {
+				return 0, false, err
+			}
+			hi = lo
+			if step > 1 {
+				hi = f.max
+			}
+		}
+		if lo > hi {
+			return 0, false, fmt.Errorf("范围[%s]的起始值大于结束值", rangeExpr)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, star, nil
+}
+
+// value 解析数字或英文缩写 并校验取值范围
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("[%s]不是有效的值", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("[%d]超出范围%d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// cronSpec 5段表达式的时间表 每段以位图表示可取的值
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	loc                           *time.Location
+}
+
+// Next 从t的下一分钟开始 逐级查找满足条件的月、日、小时、分钟
+func (s *cronSpec) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.loc)
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+
+	// 更低一级的字段是否已被重置为最小值
+	added := false
+	// 表达式可能永远无法满足 如2月30日
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// 夏令时切换时当天零点可能不存在
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t.In(origLoc)
+}
+
+// dayMatches 日和星期都不是*时 满足其一即可
+func (s *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// everySchedule @every 固定间隔执行
+type everySchedule time.Duration
+
+func (e everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e)).Truncate(time.Second)
+}
@@ -0,0 +1,129 @@
+package wxrobot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@every 500ms",
+		"@every nope",
+		"TZ=Nowhere/City * * * * *",
+		"CRON_TZ=UTC",
+	}
+	for _, spec := range specs {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) expected error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"TZ=UTC * * * * *", "2024-01-01T10:00:30Z", "2024-01-01T10:01:00Z"},
+		{"TZ=UTC 30 9 * * *", "2024-01-01T09:30:00Z", "2024-01-02T09:30:00Z"},
+		{"TZ=UTC 30 9 * * *", "2024-01-01T09:29:59Z", "2024-01-01T09:30:00Z"},
+		{"TZ=UTC */15 * * * *", "2024-01-01T10:16:00Z", "2024-01-01T10:30:00Z"},
+		{"TZ=UTC 0 9-18/3 * * *", "2024-01-01T13:00:00Z", "2024-01-01T15:00:00Z"},
+		{"TZ=UTC 0 0 * * MON-FRI", "2024-01-05T12:00:00Z", "2024-01-08T00:00:00Z"},
+		{"TZ=UTC 0 0 * * 7", "2024-01-01T00:00:00Z", "2024-01-07T00:00:00Z"},
+		{"TZ=UTC 0 0 1 JAN,jul *", "2024-02-01T00:00:00Z", "2024-07-01T00:00:00Z"},
+		{"TZ=UTC 0 0 31 * *", "2024-04-01T00:00:00Z", "2024-05-31T00:00:00Z"},
+		{"TZ=UTC 0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		// 日和星期都不是*时满足其一即可
+		{"TZ=UTC 0 0 15 * FRI", "2024-01-01T00:00:00Z", "2024-01-05T00:00:00Z"},
+		{"TZ=UTC @daily", "2024-12-31T23:59:00Z", "2025-01-01T00:00:00Z"},
+		{"TZ=UTC @hourly", "2024-01-01T10:00:00Z", "2024-01-01T11:00:00Z"},
+		{"TZ=UTC @yearly", "2024-06-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"CRON_TZ=Asia/Shanghai 0 9 * * *", "2024-01-01T02:00:00Z", "2024-01-02T01:00:00Z"},
+		// 永远无法满足的表达式
+		{"TZ=UTC 0 0 30 2 *", "2024-01-01T00:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		from, _ := time.Parse(time.RFC3339, tt.from)
+		got := s.Next(from)
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q.Next(%s) = %s, want zero", tt.spec, tt.from, got)
+			}
+			continue
+		}
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if !got.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.from, got.In(time.UTC), want)
+		}
+	}
+
+	// 未指定时区时按本地时区 返回值与参数的时区相同
+	s, _ := parseCron("0 9 * * *")
+	from := time.Date(2024, 1, 1, 8, 0, 0, 0, shanghai)
+	if got := s.Next(from); got.Location() != shanghai {
+		t.Errorf("Next returned location %s, want %s", got.Location(), shanghai)
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// 2024-03-10 02:00夏令时开始 02:30不存在 顺延到下一天
+		{"TZ=America/New_York 30 2 * * *", time.Date(2024, 3, 9, 12, 0, 0, 0, ny), time.Date(2024, 3, 11, 2, 30, 0, 0, ny)},
+		{"TZ=America/New_York 0 3 * * *", time.Date(2024, 3, 9, 12, 0, 0, 0, ny), time.Date(2024, 3, 10, 3, 0, 0, 0, ny)},
+		// 2024-11-03 02:00夏令时结束 01:30出现两次 只执行第一次
+		{"TZ=America/New_York 30 1 * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, ny), time.Date(2024, 11, 3, 1, 30, 0, 0, ny)},
+		{"TZ=America/New_York 0 0 * * *", time.Date(2024, 11, 2, 12, 0, 0, 0, ny), time.Date(2024, 11, 3, 0, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestEverySchedule(t *testing.T) {
+	s, err := parseCron("@every 1m30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 1, 1, 10, 0, 0, 500, time.UTC)
+	if got, want := s.Next(from), time.Date(2024, 1, 1, 10, 1, 30, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,495 @@
+package wxrobot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrDelayedNotFound = errors.New("wxrobot: delayed message not found or already sent")
+
+// DelayRecord 延迟发送的消息 由DelayStore保存
+type DelayRecord struct {
+	ID        string          `json:"id"`
+	At        time.Time       `json:"at"`                 // 发送时间
+	CreatedAt time.Time       `json:"created_at"`         // 创建时间
+	Data      json.RawMessage `json:"data"`               // 消息内容 内部格式
+	Attempts  int             `json:"attempts,omitempty"` // 临时错误后已重试的次数
+}
+
+// DelayStore 保存延迟发送的消息 实现持久化的存储可使消息在进程重启后继续发送
+type DelayStore interface {
+	// Save 新增或更新消息
+	Save(rec *DelayRecord) error
+	// Delete 删除消息 消息不存在时不返回错误
+	Delete(id string) error
+	// List 返回所有待发送的消息
+	List() ([]*DelayRecord, error)
+}
+
+// memoryDelayStore 内存中保存延迟消息 进程重启后丢失
+type memoryDelayStore struct {
+	mu      sync.Mutex
+	records map[string]*DelayRecord
+}
+
+// NewMemoryDelayStore 内存存储 未设置DelayStore(...)时默认使用
+func NewMemoryDelayStore() DelayStore {
+	return &memoryDelayStore{records: make(map[string]*DelayRecord)}
+}
+
+func (s *memoryDelayStore) Save(rec *DelayRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[rec.ID] = rec
+	return nil
+}
+
+func (s *memoryDelayStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+func (s *memoryDelayStore) List() ([]*DelayRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]*DelayRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// fileDelayStore 每条延迟消息保存为目录下的一个文件
+type fileDelayStore struct {
+	dir string
+}
+
+const delayRecordExt = ".delay"
+
+// NewFileDelayStore 文件存储 每条消息保存为dir下的一个文件 目录不存在时自动创建
+func NewFileDelayStore(dir string) (DelayStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileDelayStore{dir: dir}, nil
+}
+
+func (s *fileDelayStore) Save(rec *DelayRecord) error {
+	bt, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, rec.ID+delayRecordExt)
+	if err = ioutil.WriteFile(path+".tmp", bt, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *fileDelayStore) Delete(id string) error {
+	err := os.Remove(filepath.Join(s.dir, id+delayRecordExt))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileDelayStore) List() ([]*DelayRecord, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*DelayRecord
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), delayRecordExt) {
+			continue
+		}
+		bt, err := ioutil.ReadFile(filepath.Join(s.dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		rec := new(DelayRecord)
+		if err = json.Unmarshal(bt, rec); err != nil {
+			wxRobot.logger.Error(fmt.Sprintf("延迟消息[%s]已损坏, 跳过: %v", info.Name(), err))
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// DelayedMsg 延迟发送的消息 可取消或修改发送时间
+type DelayedMsg struct {
+	bot *bot
+	id  string
+}
+
+// delayQueue 机器人的延迟消息
+type delayQueue struct {
+	mu    sync.Mutex
+	store DelayStore
+	seq   uint64
+	items map[string]*delayedItem
+}
+
+type delayedItem struct {
+	rec   *DelayRecord
+	timer *time.Timer
+}
+
+// DelayStore 设置延迟消息的存储 并加载存储中待发送的消息 需在SendAt(...)之前调用
+// 使用NewFileDelayStore(dir)等持久化存储时，进程重启后会继续发送未发送的消息，已过发送时间的消息会立即发送
+// 存储中的消息均由当前机器人发送 不同机器人需使用不同的存储
+// 延迟消息由存储持久化，到时间后直接发送，不经过Async(...)的异步队列及Outbox(...)；临时错误会退避后重试直到发送成功或被取消
+func (r *bot) DelayStore(store DelayStore) *bot {
+	records, err := store.List()
+	if err != nil {
+		wxRobot.logger.Error("加载延迟消息失败:", err)
+	}
+
+	q := &r.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.store = store
+	for _, rec := range records {
+		if _, ok := q.items[rec.ID]; !ok {
+			q.schedule(r, rec)
+		}
+	}
+	return r
+}
+
+// DelayedMsgs 返回待发送的延迟消息 按发送时间排序
+func (r *bot) DelayedMsgs() []*DelayedMsg {
+	q := &r.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	recs := make([]*DelayRecord, 0, len(q.items))
+	for _, item := range q.items {
+		recs = append(recs, item.rec)
+	}
+	sort.Slice(recs, func(i, k int) bool {
+		if !recs[i].At.Equal(recs[k].At) {
+			return recs[i].At.Before(recs[k].At)
+		}
+		return recs[i].ID < recs[k].ID
+	})
+
+	msgs := make([]*DelayedMsg, len(recs))
+	for i, rec := range recs {
+		msgs[i] = &DelayedMsg{bot: r, id: rec.ID}
+	}
+	return msgs
+}
+
+// sendAt 保存消息并在at时发送
+func (r *bot) sendAt(at time.Time, cmsgs ...*toCommonMsg) (*DelayedMsg, error) {
+	entries := make([]*outboxEntry, len(cmsgs))
+	for i, cmsg := range cmsgs {
+		entry, err := newOutboxEntry(cmsg)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &r.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	rec := &DelayRecord{
+		ID:        fmt.Sprintf("%020d-%06d", now.UnixNano(), atomic.AddUint64(&q.seq, 1)%1000000),
+		At:        at,
+		CreatedAt: now,
+		Data:      data,
+	}
+	if q.store == nil {
+		q.store = NewMemoryDelayStore()
+	}
+	if err = q.store.Save(rec); err != nil {
+		return nil, err
+	}
+	q.schedule(r, rec)
+	return &DelayedMsg{bot: r, id: rec.ID}, nil
+}
+
+// schedule 到时间后发送 需持有q.mu
+func (q *delayQueue) schedule(r *bot, rec *DelayRecord) {
+	if q.items == nil {
+		q.items = make(map[string]*delayedItem)
+	}
+	q.items[rec.ID] = &delayedItem{
+		rec:   rec,
+		timer: time.AfterFunc(time.Until(rec.At), func() { r.fireDelayed(rec.ID) }),
+	}
+}
+
+// fireDelayed 发送到时间的延迟消息
+// 临时错误时保留未发送的部分并按退避策略重新安排发送，发送成功或永久错误时从存储中删除
+func (r *bot) fireDelayed(id string) {
+	q := &r.delay
+	q.mu.Lock()
+	item, ok := q.items[id]
+	delete(q.items, id)
+	store := q.store
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	var entries []*outboxEntry
+	err := json.Unmarshal(item.rec.Data, &entries)
+	sent := 0
+	for err == nil && sent < len(entries) {
+		entry := entries[sent]
+		var ok bool
+		if ok, err = r.reserveKey(entry.IdempotencyKey); err != nil {
+			break
+		}
+		// 其他实例已发送过相同IdempotencyKey的消息时跳过
+		if ok {
+			if err = r.deliverEntry(context.Background(), entry); err != nil {
+				r.releaseKey(entry.IdempotencyKey)
+				break
+			}
+		}
+		sent++
+	}
+	if err != nil && isRetryable(err) {
+		r.retryDelayed(item.rec, entries[sent:], err)
+		return
+	}
+	if err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("机器人[%s]延迟消息[%s]发送失败: %v", r.name, id, err))
+	}
+
+	if err = store.Delete(id); err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("删除延迟消息[%s]失败: %v", id, err))
+	}
+}
+
+// retryDelayed 临时错误后保存未发送的部分 退避后重新发送
+func (r *bot) retryDelayed(rec *DelayRecord, remaining []*outboxEntry, cause error) {
+	data, err := json.Marshal(remaining)
+	if err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("机器人[%s]延迟消息[%s]发送失败: %v", r.name, rec.ID, cause))
+		return
+	}
+
+	retry := *rec
+	retry.Attempts++
+	retry.Data = data
+	wait := outboxReplayPolicy.backoff(retry.Attempts)
+	retry.At = time.Now().Add(wait)
+	wxRobot.logger.Warn(fmt.Sprintf("机器人[%s]延迟消息[%s]发送失败，%v后重试, err: %v", r.name, rec.ID, wait, cause))
+
+	q := &r.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err = q.store.Save(&retry); err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("保存延迟消息[%s]失败: %v", rec.ID, err))
+	}
+	q.schedule(r, &retry)
+}
+
+// ID 消息id
+func (d *DelayedMsg) ID() string {
+	return d.id
+}
+
+// At 发送时间 消息已发送或已取消时返回零值
+func (d *DelayedMsg) At() time.Time {
+	q := &d.bot.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if item, ok := q.items[d.id]; ok {
+		return item.rec.At
+	}
+	return time.Time{}
+}
+
+// Cancel 取消发送 消息已发送或正在发送时返回ErrDelayedNotFound
+func (d *DelayedMsg) Cancel() error {
+	q := &d.bot.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, ok := q.items[d.id]
+	if !ok || !item.timer.Stop() {
+		return ErrDelayedNotFound
+	}
+	delete(q.items, d.id)
+	return q.store.Delete(d.id)
+}
+
+// Reschedule 修改发送时间 消息已发送或正在发送时返回ErrDelayedNotFound
+func (d *DelayedMsg) Reschedule(at time.Time) error {
+	q := &d.bot.delay
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, ok := q.items[d.id]
+	if !ok || !item.timer.Stop() {
+		return ErrDelayedNotFound
+	}
+
+	rec := *item.rec
+	rec.At = at
+	if err := q.store.Save(&rec); err != nil {
+		item.timer.Reset(time.Until(item.rec.At))
+		return err
+	}
+	item.rec = &rec
+	item.timer.Reset(time.Until(at))
+	return nil
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (t *toMsgText) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	var cmsgs []*toCommonMsg
+	for _, part := range t.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Text = part
+		cmsgs = append(cmsgs, cmsg)
+	}
+	return t.bot.sendAt(at, cmsgs...)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (t *toMsgText) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return t.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgMarkdown) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	var cmsgs []*toCommonMsg
+	for _, part := range tm.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Markdown = part
+		cmsgs = append(cmsgs, cmsg)
+	}
+	return tm.bot.sendAt(at, cmsgs...)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgMarkdown) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgMarkdownV2) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.MarkdownV2 = tm
+	return tm.bot.sendAt(at, cmsg)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgMarkdownV2) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgNews) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.News = tm
+	return tm.bot.sendAt(at, cmsg)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgNews) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgImage) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Image = tm
+	return tm.bot.sendAt(at, cmsg)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgImage) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+// 文件在发送时才上传，通过FileFromPath(...)发送时需保证届时文件仍然存在
+func (tm *toMsgFile) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.File = tm
+	return tm.bot.sendAt(at, cmsg)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgFile) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgVoice) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Voice = tm
+	return tm.bot.sendAt(at, cmsg)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgVoice) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
+
+// SendAt 在指定时间发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgTemplateCard) SendAt(at time.Time) (*DelayedMsg, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.TemplateCard = tm
+	return tm.bot.sendAt(at, cmsg)
+}
+
+// SendAfter 在d之后发送消息 返回的DelayedMsg可取消或修改发送时间
+func (tm *toMsgTemplateCard) SendAfter(d time.Duration) (*DelayedMsg, error) {
+	return tm.SendAt(time.Now().Add(d))
+}
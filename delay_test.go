@@ -0,0 +1,211 @@
+package wxrobot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelayedSend(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	b := Bot("delay-test-send").WebhookURL(srv.webhook())
+
+	later, err := b.ToTextMsg("later").SendAfter(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	soon, err := b.ToTextMsg("soon").SendAfter(20 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs := b.DelayedMsgs(); len(msgs) != 2 || msgs[0].ID() != soon.ID() || msgs[1].ID() != later.ID() {
+		t.Fatalf("DelayedMsgs not sorted by send time")
+	}
+
+	// 提前到现在发送
+	if err = later.Reschedule(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(2*time.Second, func() bool { return len(srv.contents()) == 2 }) {
+		t.Fatalf("received %v, want 2 messages", srv.contents())
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[later soon]" {
+		t.Errorf("received %s, want [later soon]", got)
+	}
+	// 已发送的消息不能取消或修改
+	if err = soon.Cancel(); !errors.Is(err, ErrDelayedNotFound) {
+		t.Errorf("Cancel after send: err = %v, want ErrDelayedNotFound", err)
+	}
+	if err = later.Reschedule(time.Now().Add(time.Hour)); !errors.Is(err, ErrDelayedNotFound) {
+		t.Errorf("Reschedule after send: err = %v, want ErrDelayedNotFound", err)
+	}
+	if !later.At().IsZero() || len(b.DelayedMsgs()) != 0 {
+		t.Errorf("sent messages should be removed")
+	}
+}
+
+func TestDelayedCancel(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	b := Bot("delay-test-cancel").WebhookURL(srv.webhook())
+
+	msg, err := b.ToTextMsg("canceled").SendAfter(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(time.Hour)
+	if err = msg.Reschedule(at); err != nil {
+		t.Fatal(err)
+	}
+	if !msg.At().Equal(at) {
+		t.Errorf("At() = %v, want %v", msg.At(), at)
+	}
+	if err = msg.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if err = msg.Cancel(); !errors.Is(err, ErrDelayedNotFound) {
+		t.Errorf("second Cancel: err = %v, want ErrDelayedNotFound", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := srv.contents(); len(got) != 0 {
+		t.Errorf("canceled message was sent: %v", got)
+	}
+}
+
+func TestDelayedCancelRace(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	b := Bot("delay-test-race").WebhookURL(srv.webhook())
+
+	// 取消、修改与定时器触发并发时 每条消息要么取消成功 要么恰好发送一次
+	const n = 100
+	var mu sync.Mutex
+	want := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		content := fmt.Sprintf("%d", i)
+		msg, err := b.ToTextMsg(content).SendAfter(time.Duration(i%5) * time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				err = msg.Cancel()
+			} else {
+				err = msg.Reschedule(time.Now().Add(time.Millisecond))
+			}
+			switch {
+			case i%2 == 1 || errors.Is(err, ErrDelayedNotFound):
+				mu.Lock()
+				want[content] = true
+				mu.Unlock()
+			case err != nil:
+				t.Errorf("message %s: %v", content, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if !waitFor(2*time.Second, func() bool { return len(srv.contents()) >= len(want) && len(b.DelayedMsgs()) == 0 }) {
+		t.Fatalf("received %d messages, want %d", len(srv.contents()), len(want))
+	}
+	time.Sleep(20 * time.Millisecond)
+	received := make(map[string]bool)
+	for _, content := range srv.contents() {
+		if received[content] || !want[content] {
+			t.Errorf("message %s sent unexpectedly or more than once", content)
+		}
+		received[content] = true
+	}
+	if len(received) != len(want) {
+		t.Errorf("received %d distinct messages, want %d", len(received), len(want))
+	}
+}
+
+func TestDelayedRetry(t *testing.T) {
+	policy := outboxReplayPolicy
+	outboxReplayPolicy = RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	defer func() { outboxReplayPolicy = policy }()
+
+	srv := newRecordServer(t, func(n int, msg recordedMsg) string {
+		switch {
+		case msg.Content == "bad":
+			return `{"errcode":93000,"errmsg":"invalid webhook url"}`
+		case msg.Content == "flaky" && n <= 2:
+			return "503"
+		}
+		return `{"errcode":0}`
+	})
+	store := NewMemoryDelayStore()
+	b := Bot("delay-test-retry").WebhookURL(srv.webhook()).DelayStore(store)
+
+	// 临时错误后退避重试直到成功
+	if _, err := b.ToTextMsg("flaky").SendAfter(0); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(2*time.Second, func() bool { return len(srv.contents()) == 1 }) {
+		t.Fatalf("flaky message was not retried")
+	}
+
+	// 永久错误时不再重试 从存储中删除
+	if _, err := b.ToTextMsg("bad").SendAfter(0); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(2*time.Second, func() bool {
+		recs, _ := store.List()
+		return len(recs) == 0 && len(b.DelayedMsgs()) == 0
+	}) {
+		t.Fatal("delayed messages left in the store")
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[flaky]" {
+		t.Errorf("received %s, want [flaky]", got)
+	}
+}
+
+func TestDelayStoreRestart(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	store, err := NewFileDelayStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟进程退出前保存的延迟消息 一条已过发送时间 一条未到时间
+	b := Bot("delay-test-restart").WebhookURL(srv.webhook())
+	for i, content := range []string{"overdue", "pending"} {
+		msg := b.ToTextMsg(content)
+		cmsg := msg.buildCommonMsg()
+		cmsg.Text = msg
+		entry, err := newOutboxEntry(cmsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal([]*outboxEntry{entry})
+		rec := &DelayRecord{ID: content, At: time.Now().Add(time.Duration(i) * time.Hour), CreatedAt: time.Now(), Data: data}
+		if err = store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.DelayStore(store)
+	if !waitFor(2*time.Second, func() bool { return len(srv.contents()) == 1 }) {
+		t.Fatal("overdue message was not sent after restart")
+	}
+	recs, err := store.List()
+	if err != nil || len(recs) != 1 || recs[0].ID != "pending" {
+		t.Fatalf("store after restart = %v, %v, want only the pending message", recs, err)
+	}
+	msgs := b.DelayedMsgs()
+	if len(msgs) != 1 || msgs[0].ID() != "pending" {
+		t.Fatalf("DelayedMsgs = %v, want the pending message", msgs)
+	}
+	if err = msgs[0].Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if recs, _ = store.List(); len(recs) != 0 {
+		t.Errorf("canceled message left in the store")
+	}
+}
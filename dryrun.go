@@ -0,0 +1,170 @@
+package wxrobot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// dryRunMediaPrefix dry-run模式下上传文件返回的media_id前缀
+const dryRunMediaPrefix = "dry-run-"
+
+// SwitchDryRunMode 设置机器人的dry-run模式
+// 开启后发送消息及上传文件都不会发起网络请求，消息的JSON会记录到日志中，并回调recorder(如有)
+// 上传文件返回以dry-run-开头的虚拟media_id
+func (r *bot) SwitchDryRunMode(open bool, recorder ...func(payload []byte)) *bot {
+	r.dryRun = open
+	r.dryRunRecorder = nil
+	if len(recorder) > 0 {
+		r.dryRunRecorder = recorder[0]
+	}
+	return r
+}
+
+// dryRunPost dry-run模式下代替post记录消息
+func (r *bot) dryRunPost(bt []byte) error {
+	wxRobot.logger.Info(fmt.Sprintf("[dry-run] 机器人[%s]发送消息: %s", r.name, string(bt)))
+	if r.dryRunRecorder != nil {
+		r.dryRunRecorder(bt)
+	}
+	return nil
+}
+
+// dryRunUpload dry-run模式下代替upload 读取文件内容计算虚拟的media_id
+func (r *bot) dryRunUpload(filename string, src io.Reader, mediaType MediaType) (*upLoadRes, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return nil, err
+	}
+
+	wxRobot.logger.Info(fmt.Sprintf("[dry-run] 机器人[%s]上传文件: %s", r.name, filename))
+	return &upLoadRes{
+		MediaId:   dryRunMediaPrefix + hex.EncodeToString(h.Sum(nil))[:16],
+		Type:      string(mediaType),
+		CreatedAt: time.Now().Unix(),
+	}, nil
+}
+
+// payload 返回消息实际发送的JSON
+func (tc *toCommonMsg) payload() ([]byte, error) {
+	return json.Marshal(tc)
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+// 内容会被拆分为多条消息时返回错误，此时请使用Payloads()
+func (t *toMsgText) Payload() ([]byte, error) {
+	payloads, err := t.Payloads()
+	if err != nil {
+		return nil, err
+	}
+	if len(payloads) > 1 {
+		return nil, fmt.Errorf("消息将被拆分为%d条发送, 请使用Payloads()", len(payloads))
+	}
+	return payloads[0], nil
+}
+
+// Payloads 返回Send实际依次发送的JSON 内容未拆分时只有一条
+func (t *toMsgText) Payloads() ([][]byte, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	var payloads [][]byte
+	for _, part := range t.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Text = part
+		bt, err := cmsg.payload()
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, bt)
+	}
+	return payloads, nil
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+// 内容会被拆分为多条消息时返回错误，此时请使用Payloads()
+func (tm *toMsgMarkdown) Payload() ([]byte, error) {
+	payloads, err := tm.Payloads()
+	if err != nil {
+		return nil, err
+	}
+	if len(payloads) > 1 {
+		return nil, fmt.Errorf("消息将被拆分为%d条发送, 请使用Payloads()", len(payloads))
+	}
+	return payloads[0], nil
+}
+
+// Payloads 返回Send实际依次发送的JSON 内容未拆分时只有一条
+func (tm *toMsgMarkdown) Payloads() ([][]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+
+	var payloads [][]byte
+	for _, part := range tm.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Markdown = part
+		bt, err := cmsg.payload()
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, bt)
+	}
+	return payloads, nil
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+func (tm *toMsgNews) Payload() ([]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.News = tm
+	return cmsg.payload()
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求 开启AutoConvert()时为转换后的图片
+func (tm *toMsgImage) Payload() ([]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Image = tm
+	return cmsg.payload()
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+// 文件尚未上传时media_id为空，发送时会替换为上传后的media_id
+func (tm *toMsgFile) Payload() ([]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.File = tm
+	return cmsg.payload()
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+// 语音尚未上传时media_id为空，发送时会替换为上传后的media_id
+func (tm *toMsgVoice) Payload() ([]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Voice = tm
+	return cmsg.payload()
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+func (tm *toMsgTemplateCard) Payload() ([]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.TemplateCard = tm
+	return cmsg.payload()
+}
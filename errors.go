@@ -0,0 +1,91 @@
+package wxrobot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 企业微信常见的错误码
+const (
+	ErrCodeInvalidMediaID    = 40007 // 不合法的媒体文件id
+	ErrCodeInvalidParameter  = 40058 // 不合法的参数 消息内容超过长度限制时也返回该错误码
+	ErrCodeRateLimitExceeded = 45009 // 接口调用超过限制
+	ErrCodeInvalidWebhookKey = 93000 // 无效的webhook url 通常是key错误或机器人已被删除
+)
+
+// 常见错误码对应的哨兵错误 可通过 errors.Is(err, ErrRateLimitExceeded) 判断
+var (
+	ErrInvalidMediaID    = errors.New("wxrobot: invalid media_id")
+	ErrInvalidParameter  = errors.New("wxrobot: invalid parameter")
+	ErrContentTooLong    = errors.New("wxrobot: content too long")
+	ErrRateLimitExceeded = errors.New("wxrobot: rate limit exceeded")
+	ErrInvalidWebhookKey = errors.New("wxrobot: invalid webhook key")
+)
+
+var errCodeSentinels = map[int]error{
+	ErrCodeInvalidMediaID:    ErrInvalidMediaID,
+	ErrCodeInvalidParameter:  ErrInvalidParameter,
+	ErrCodeRateLimitExceeded: ErrRateLimitExceeded,
+	ErrCodeInvalidWebhookKey: ErrInvalidWebhookKey,
+}
+
+// APIError 企业微信接口返回的错误
+//
+// HTTP状态码非200、响应体不是合法的JSON或errcode不为0时返回，可通过 errors.As 获取
+type APIError struct {
+	ErrCode    int    // 企业微信返回的errcode 响应无法解析时为0
+	ErrMsg     string // 企业微信返回的errmsg 或响应无法解析的原因
+	StatusCode int    // HTTP状态码
+	Body       []byte // 原始响应体
+}
+
+// Error
+func (e *APIError) Error() string {
+	if e.ErrCode != 0 {
+		return fmt.Sprintf("wxrobot: errcode=%d errmsg=%s", e.ErrCode, e.ErrMsg)
+	}
+	return fmt.Sprintf("wxrobot: http status %d: %s", e.StatusCode, e.ErrMsg)
+}
+
+// Is 支持 errors.Is 与常见错误码的哨兵错误比较
+// 40058是通用的参数错误 仅当errmsg说明内容超长时才视为ErrContentTooLong
+func (e *APIError) Is(target error) bool {
+	if target == ErrContentTooLong {
+		return e.ErrCode == ErrCodeInvalidParameter && strings.Contains(strings.ToLower(e.ErrMsg), "exceed max length")
+	}
+	sentinel, ok := errCodeSentinels[e.ErrCode]
+	return ok && sentinel == target
+}
+
+// apiResponse 企业微信接口的公共响应字段
+type apiResponse interface {
+	errInfo() (int, string)
+}
+
+func (s *sendResponse) errInfo() (int, string) {
+	return s.ErrCode, s.ErrMsg
+}
+
+func (u *upLoadRes) errInfo() (int, string) {
+	return u.ErrCode, u.ErrMsg
+}
+
+// parseResponse 解析接口响应到out 任何异常的响应都会返回 *APIError
+func parseResponse(statusCode int, body []byte, out apiResponse) error {
+	if statusCode != http.StatusOK {
+		return &APIError{StatusCode: statusCode, ErrMsg: http.StatusText(statusCode), Body: body}
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return &APIError{StatusCode: statusCode, ErrMsg: fmt.Sprintf("invalid response: %v", err), Body: body}
+	}
+
+	if code, msg := out.errInfo(); code != 0 {
+		return &APIError{ErrCode: code, ErrMsg: msg, StatusCode: statusCode, Body: body}
+	}
+
+	return nil
+}
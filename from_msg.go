@@ -32,12 +32,15 @@ type FromCommonMsg struct {
 	WebhookUrl     string `xml:"WebhookUrl"`     // 机器人主动推送消息的url
 	ChatId         string `xml:"ChatId"`         // 会话id，可能是群聊，也可能是单聊，也可能是小黑板
 	GetChatInfoUrl string `xml:"GetChatInfoUrl"` // 获取群信息的URL，有效时间5分钟，且仅能调用一次，当ChatType是single时不提供该字段。
+
+	session *Session // Session()读取的会话
 }
 
 func (r *FromCommonMsg) ToTextMsg(msg string) *toMsgText {
 	text := new(toMsgText)
 	text.bot = r.bot
 	text.ChatId(r.ChatId)
+	text.chatType = r.GetChatType()
 	text.msgType = "text"
 	text.Content = msg
 	return text
@@ -47,15 +50,27 @@ func (r *FromCommonMsg) ToMarkdownMsg(markdown string) *toMsgMarkdown {
 	t := new(toMsgMarkdown)
 	t.bot = r.bot
 	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
 	t.msgType = "markdown"
 	t.Content = markdown
 	return t
 }
 
+func (r *FromCommonMsg) ToMarkdownV2Msg(markdown string) *toMsgMarkdownV2 {
+	t := new(toMsgMarkdownV2)
+	t.bot = r.bot
+	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
+	t.msgType = "markdown_v2"
+	t.Content = markdown
+	return t
+}
+
 func (r *FromCommonMsg) ToImageMsg() *toMsgImage {
 	t := new(toMsgImage)
 	t.bot = r.bot
 	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
 	t.msgType = "image"
 	return t
 }
@@ -64,6 +79,7 @@ func (r *FromCommonMsg) ToNewsMsg() *toMsgNews {
 	t := new(toMsgNews)
 	t.bot = r.bot
 	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
 	t.msgType = "news"
 	return t
 }
@@ -72,10 +88,30 @@ func (r *FromCommonMsg) ToFileMsg() *toMsgFile {
 	t := new(toMsgFile)
 	t.bot = r.bot
 	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
 	t.msgType = "file"
 	return t
 }
 
+func (r *FromCommonMsg) ToVoiceMsg() *toMsgVoice {
+	t := new(toMsgVoice)
+	t.bot = r.bot
+	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
+	t.msgType = "voice"
+	return t
+}
+
+func (r *FromCommonMsg) ToTemplateCardMsg(cardType TemplateCardType) *toMsgTemplateCard {
+	t := new(toMsgTemplateCard)
+	t.bot = r.bot
+	t.ChatId(r.ChatId)
+	t.chatType = r.GetChatType()
+	t.msgType = "template_card"
+	t.CardType = cardType
+	return t
+}
+
 func (r *FromCommonMsg) GetChatType() ChatType {
 	return ChatType(r.ChatType)
 }
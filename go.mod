@@ -1,5 +1,8 @@
 module github.com/Godhuu/wxrobot
 
-go 1.17
+go 1.18
 
-require github.com/gorilla/mux v1.8.0 // indirect
+require (
+	github.com/gorilla/mux v1.8.0
+	golang.org/x/image v0.18.0
+)
@@ -104,6 +104,9 @@ func (r *bot) replyHandler(msgContent *FromCommonMsg, msgBody []byte) {
 		var msg FromTextMsg
 		_ = xml.Unmarshal(msgBody, &msg)
 		msg.bot = r
+		if r.commands.dispatch(&msg) {
+			return
+		}
 		if r.textHandler == nil {
 			defaultTextHandler(&msg)
 			return
@@ -140,6 +143,13 @@ func (r *bot) replyHandler(msgContent *FromCommonMsg, msgBody []byte) {
 		var msg FromAttachmentMsg
 		_ = xml.Unmarshal(msgBody, &msg)
 		msg.bot = r
+		if err := r.verifyAttachment(&msg); err != nil {
+			wxRobot.logger.Warn(fmt.Sprintf("用户[%s]的点击事件校验失败, 已忽略: %v", msg.From.UserId, err))
+			return
+		}
+		if r.buttons.dispatch(&msg) {
+			return
+		}
 		if r.attachmentHandler == nil {
 			defaultAttachmentHandler(&msg)
 			return
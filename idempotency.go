@@ -0,0 +1,183 @@
+package wxrobot
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyWindow 默认的去重时间窗口
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// IdempotencyStore 记录已发送消息的IdempotencyKey 多个实例共用同一存储时可跨实例去重
+type IdempotencyStore interface {
+	// Reserve 记录key ttl后过期 key已存在且未过期时返回false
+	// 多个实例并发调用时需保证只有一个返回true
+	Reserve(key string, ttl time.Duration) (bool, error)
+	// Release 删除key 消息发送失败时调用 以便重试时可以再次发送
+	Release(key string) error
+}
+
+// memoryIdempotencyStore 内存中记录IdempotencyKey 仅在当前进程内去重
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	keys      map[string]time.Time // key的过期时间
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore 内存存储 未指定存储时默认使用
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{keys: make(map[string]time.Time)}
+}
+
+func (s *memoryIdempotencyStore) Reserve(key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// 定期清理过期的key
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, expireAt := range s.keys {
+			if now.After(expireAt) {
+				delete(s.keys, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if expireAt, ok := s.keys[key]; ok && now.Before(expireAt) {
+		return false, nil
+	}
+	s.keys[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+// idempotency 机器人的去重配置
+type idempotency struct {
+	window time.Duration
+	store  IdempotencyStore
+}
+
+// defaultIdempotency 未调用Idempotency(...)时使用 key中包含机器人名字 多个机器人可共用
+var defaultIdempotency = &idempotency{window: DefaultIdempotencyWindow, store: NewMemoryIdempotencyStore()}
+
+// Idempotency 设置消息去重的时间窗口 window内IdempotencyKey相同的消息只发送一次
+// window小于等于0时为DefaultIdempotencyWindow；store为空时使用内存存储，多个实例间去重需传入共享的存储
+func (r *bot) Idempotency(window time.Duration, store ...IdempotencyStore) *bot {
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	idem := &idempotency{window: window}
+	if len(store) > 0 && store[0] != nil {
+		idem.store = store[0]
+	} else {
+		idem.store = NewMemoryIdempotencyStore()
+	}
+	r.idempotency = idem
+	return r
+}
+
+func (r *bot) idem() *idempotency {
+	if r.idempotency != nil {
+		return r.idempotency
+	}
+	return defaultIdempotency
+}
+
+// reserve 发送前记录IdempotencyKey 已在时间窗口内发送过时返回false
+func (tc *toCommonMsg) reserve() (bool, error) {
+	return tc.bot.reserveKey(tc.idempotencyKey)
+}
+
+// release 发送失败后删除IdempotencyKey
+func (tc *toCommonMsg) release() {
+	tc.bot.releaseKey(tc.idempotencyKey)
+}
+
+// reserveKey 记录IdempotencyKey key为空时不去重
+func (r *bot) reserveKey(key string) (bool, error) {
+	if key == "" {
+		return true, nil
+	}
+	idem := r.idem()
+	ok, err := idem.store.Reserve(r.name+":"+key, idem.window)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		wxRobot.logger.Info("skip duplicate message", key, "for", r.name)
+	}
+	return ok, nil
+}
+
+func (r *bot) releaseKey(key string) {
+	if key == "" {
+		return
+	}
+	if err := r.idem().store.Release(r.name + ":" + key); err != nil {
+		wxRobot.logger.Error("release idempotency key", key, "err:", err)
+	}
+}
+
+// partKey 消息拆分为多条发送时 每条使用不同的key
+func partKey(key string, i int) string {
+	if key == "" || i == 0 {
+		return key
+	}
+	return key + "#" + strconv.Itoa(i+1)
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (t *toMsgText) IdempotencyKey(key string) *toMsgText {
+	t.idempotencyKey = key
+	return t
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgMarkdown) IdempotencyKey(key string) *toMsgMarkdown {
+	tm.idempotencyKey = key
+	return tm
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgMarkdownV2) IdempotencyKey(key string) *toMsgMarkdownV2 {
+	tm.idempotencyKey = key
+	return tm
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgNews) IdempotencyKey(key string) *toMsgNews {
+	tm.idempotencyKey = key
+	return tm
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgImage) IdempotencyKey(key string) *toMsgImage {
+	tm.idempotencyKey = key
+	return tm
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgFile) IdempotencyKey(key string) *toMsgFile {
+	tm.idempotencyKey = key
+	return tm
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgVoice) IdempotencyKey(key string) *toMsgVoice {
+	tm.idempotencyKey = key
+	return tm
+}
+
+// IdempotencyKey 消息的去重key 在bot.Idempotency(...)设置的时间窗口内 key相同的消息只发送一次 SendAt/SendAfter的消息在到时间发送时去重
+func (tm *toMsgTemplateCard) IdempotencyKey(key string) *toMsgTemplateCard {
+	tm.idempotencyKey = key
+	return tm
+}
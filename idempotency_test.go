@@ -0,0 +1,89 @@
+package wxrobot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := NewMemoryIdempotencyStore()
+	if ok, err := s.Reserve("a", 20*time.Millisecond); !ok || err != nil {
+		t.Fatalf("first Reserve = %v, %v", ok, err)
+	}
+	if ok, _ := s.Reserve("a", 20*time.Millisecond); ok {
+		t.Error("Reserve within ttl should return false")
+	}
+	if ok, _ := s.Reserve("b", 20*time.Millisecond); !ok {
+		t.Error("Reserve of another key should return true")
+	}
+
+	// 过期或删除后可以再次记录
+	time.Sleep(30 * time.Millisecond)
+	if ok, _ := s.Reserve("a", time.Hour); !ok {
+		t.Error("Reserve after ttl should return true")
+	}
+	if err := s.Release("a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Reserve("a", time.Hour); !ok {
+		t.Error("Reserve after Release should return true")
+	}
+}
+
+func TestIdempotencySend(t *testing.T) {
+	srv := newRecordServer(t, func(n int, msg recordedMsg) string {
+		if n == 2 {
+			return "503"
+		}
+		return `{"errcode":0}`
+	})
+	store := NewMemoryIdempotencyStore()
+	b := Bot("idempotency-test-send").WebhookURL(srv.webhook()).Idempotency(time.Hour, store)
+
+	// 第一次发送成功 重复的消息不发送
+	if err := b.ToTextMsg("once").IdempotencyKey("k1").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ToTextMsg("once").IdempotencyKey("k1").Send(); err != nil {
+		t.Fatalf("duplicate Send: %v", err)
+	}
+
+	// 发送失败时释放key 重试时可以再次发送
+	if err := b.ToTextMsg("retried").IdempotencyKey("k2").Send(); err == nil {
+		t.Fatal("expected 503 error")
+	}
+	if err := b.ToTextMsg("retried").IdempotencyKey("k2").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ToTextMsg("retried").IdempotencyKey("k2").Send(); err != nil {
+		t.Fatalf("duplicate Send: %v", err)
+	}
+
+	// 共用存储的不同机器人的key互不影响
+	other := Bot("idempotency-test-other").WebhookURL(srv.webhook()).Idempotency(time.Hour, store)
+	if err := other.ToTextMsg("other").IdempotencyKey("k1").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[once retried other]" {
+		t.Errorf("received %s, want [once retried other]", got)
+	}
+}
+
+func TestIdempotencyDelayed(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	b := Bot("idempotency-test-delayed").WebhookURL(srv.webhook()).Idempotency(time.Hour)
+
+	// 多次安排的相同提醒在到时间发送时去重
+	for i := 0; i < 3; i++ {
+		if _, err := b.ToTextMsg("reminder").IdempotencyKey("standup").SendAfter(time.Duration(i) * 10 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !waitFor(2*time.Second, func() bool { return len(b.DelayedMsgs()) == 0 }) {
+		t.Fatal("delayed messages were not sent")
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[reminder]" {
+		t.Errorf("received %s, want [reminder]", got)
+	}
+}
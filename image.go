@@ -0,0 +1,137 @@
+package wxrobot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // 注册gif解码器
+	"image/jpeg"
+	_ "image/png" // 注册png解码器
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	_ "golang.org/x/image/bmp" // 注册bmp解码器
+	"golang.org/x/image/draw"
+)
+
+// MaxImageBytes 图片消息的图片最大不能超过2M
+const MaxImageBytes = 2 << 20
+
+var (
+	ErrImageFormat   = errors.New("wxrobot: image format must be jpg or png")
+	ErrImageTooLarge = errors.New("wxrobot: image exceeds 2MB")
+)
+
+// 自动转换时依次尝试的JPEG质量及每次缩小的比例
+var (
+	jpegQualities   = []int{90, 80, 70, 60}
+	imageScaleRatio = 0.75
+)
+
+// ImageFromReader 从r读取图片 要求同Image(...)
+func (tm *toMsgImage) ImageFromReader(r io.Reader) *toMsgImage {
+	bts, err := ioutil.ReadAll(r)
+	if err != nil {
+		tm.err = fmt.Errorf("读取图片失败: %w", err)
+		return tm
+	}
+	return tm.Image(bts)
+}
+
+// ImageFromFile 从文件读取图片 要求同Image(...)
+func (tm *toMsgImage) ImageFromFile(path string) *toMsgImage {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		tm.err = fmt.Errorf("读取图片失败: %w", err)
+		return tm
+	}
+	return tm.Image(bts)
+}
+
+// AutoConvert 开启图片自动转换
+// 非JPG/PNG格式的图片(如GIF,BMP)会被转换为JPEG，超过2M的图片会降低质量或缩小尺寸直到满足大小限制
+func (tm *toMsgImage) AutoConvert() *toMsgImage {
+	tm.autoConvert = true
+	return tm
+}
+
+// prepare 发送前校验图片 开启自动转换时按需转换 错误通过Send返回
+func (tm *toMsgImage) prepare() error {
+	if tm.err != nil {
+		return tm.err
+	}
+	if tm.raw == nil {
+		if tm.Base64 == "" {
+			return fmt.Errorf("请先调用Image(...)方法设置要发送的图片")
+		}
+		return nil
+	}
+
+	bts, err := checkImage(tm.raw)
+	if err != nil && tm.autoConvert {
+		bts, err = convertImage(tm.raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	tm.setImage(bts)
+	tm.raw = nil
+	return nil
+}
+
+// checkImage 校验图片格式及大小
+func checkImage(bts []byte) ([]byte, error) {
+	switch http.DetectContentType(bts) {
+	case "image/jpeg", "image/png":
+	default:
+		return nil, ErrImageFormat
+	}
+	if len(bts) > MaxImageBytes {
+		return nil, ErrImageTooLarge
+	}
+	return bts, nil
+}
+
+// convertImage 将图片转换为不超过2M的JPEG 先降低质量 仍超出时逐步缩小尺寸
+func convertImage(bts []byte) ([]byte, error) {
+	src, format, err := image.Decode(bytes.NewReader(bts))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImageFormat, err)
+	}
+	wxRobot.logger.Debug("convert image, format:", format, "size:", len(bts))
+
+	img := flatten(src)
+	for {
+		for _, quality := range jpegQualities {
+			var buf bytes.Buffer
+			if err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			if buf.Len() <= MaxImageBytes {
+				return buf.Bytes(), nil
+			}
+		}
+
+		bounds := img.Bounds()
+		w, h := int(float64(bounds.Dx())*imageScaleRatio), int(float64(bounds.Dy())*imageScaleRatio)
+		if w < 1 || h < 1 {
+			return nil, ErrImageTooLarge
+		}
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+	}
+}
+
+// flatten 将图片绘制到白色背景上 避免透明区域转换为JPEG后变黑
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+	return dst
+}
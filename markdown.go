@@ -0,0 +1,215 @@
+package wxrobot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// FontColor markdown中<font>标签支持的颜色
+type FontColor string
+
+const (
+	FontColorInfo    FontColor = "info"    // 绿色
+	FontColorComment FontColor = "comment" // 灰色
+	FontColorWarning FontColor = "warning" // 橙红色
+)
+
+// markdownEscaper 转义标签语法 避免插入的用户文本破坏消息结构
+// 企业微信的markdown不支持反斜杠转义，因此只将<、>、&替换为HTML实体，防止注入<font>、<@userid>等标签
+var markdownEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+)
+
+// linkTextEscaper 链接文本中的方括号会截断链接语法 替换为全角方括号
+var linkTextEscaper = strings.NewReplacer("[", "［", "]", "］")
+
+// codeEscaper 行内代码中无法使用反斜杠转义 反引号替换为单引号
+var codeEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"`", "'",
+)
+
+// urlEscaper 链接地址中会截断markdown链接语法的字符
+var urlEscaper = strings.NewReplacer(
+	" ", "%20",
+	"(", "%28",
+	")", "%29",
+	"<", "%3C",
+	">", "%3E",
+	"\"", "%22",
+	"\n", "",
+	"\r", "",
+)
+
+// EscapeMarkdown 转义文本中的标签语法及@提及 *、_等markdown语法不转义
+func EscapeMarkdown(text string) string {
+	return escapeMentions(markdownEscaper.Replace(singleLine.Replace(text)))
+}
+
+// escapeMentions 在单词开头的@后插入零宽空格 防止开启AtShortName时@到他人
+// 单词中间的@(如邮箱ops@corp.com)不会被识别为提及 保持原样
+func escapeMentions(text string) string {
+	if !strings.Contains(text, "@") {
+		return text
+	}
+	var sb strings.Builder
+	var prev rune = ' '
+	for _, r := range text {
+		sb.WriteRune(r)
+		if r == '@' && !isWordRune(prev) {
+			sb.WriteString("\u200b")
+		}
+		prev = r
+	}
+	return sb.String()
+}
+
+// isWordRune 邮箱等单词中@之前可能出现的字符
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-+", r)
+}
+
+// singleLine 行内元素不能换行 换行替换为空格
+var singleLine = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// MarkdownBuilder 拼接企业微信markdown内容 传入的文本均会被转义
+// 例: wxrobot.NewMarkdown().Heading(2, "发布通知").Text("服务: ").Font(wxrobot.FontColorInfo, name).String()
+type MarkdownBuilder struct {
+	sb strings.Builder
+}
+
+// NewMarkdown 创建markdown内容
+func NewMarkdown() *MarkdownBuilder {
+	return new(MarkdownBuilder)
+}
+
+// Text 普通文本 文本中的换行会保留
+func (m *MarkdownBuilder) Text(text string) *MarkdownBuilder {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			m.sb.WriteString("\n")
+		}
+		m.sb.WriteString(EscapeMarkdown(line))
+	}
+	return m
+}
+
+// Textf 格式化后的普通文本
+func (m *MarkdownBuilder) Textf(format string, args ...interface{}) *MarkdownBuilder {
+	return m.Text(fmt.Sprintf(format, args...))
+}
+
+// Raw 不转义直接写入markdown内容 只应用于可信的内容
+func (m *MarkdownBuilder) Raw(markdown string) *MarkdownBuilder {
+	m.sb.WriteString(markdown)
+	return m
+}
+
+// Heading 标题 level为1~6 超出范围时取最近的有效值 标题单独占一行
+func (m *MarkdownBuilder) Heading(level int, text string) *MarkdownBuilder {
+	if level < 1 {
+		level = 1
+	}
+	if level > 6 {
+		level = 6
+	}
+	m.lineStart()
+	m.sb.WriteString(strings.Repeat("#", level) + " " + EscapeMarkdown(text) + "\n")
+	return m
+}
+
+// Bold 加粗
+func (m *MarkdownBuilder) Bold(text string) *MarkdownBuilder {
+	m.sb.WriteString("**" + EscapeMarkdown(text) + "**")
+	return m
+}
+
+// Link 链接 text为展示的文本
+func (m *MarkdownBuilder) Link(text, link string) *MarkdownBuilder {
+	m.sb.WriteString("[" + linkTextEscaper.Replace(EscapeMarkdown(text)) + "](" + urlEscaper.Replace(link) + ")")
+	return m
+}
+
+// Code 行内代码
+func (m *MarkdownBuilder) Code(text string) *MarkdownBuilder {
+	m.sb.WriteString("`" + codeEscaper.Replace(singleLine.Replace(text)) + "`")
+	return m
+}
+
+// Quote 引用 多行文本的每一行都会被引用 引用单独占一行
+func (m *MarkdownBuilder) Quote(text string) *MarkdownBuilder {
+	m.lineStart()
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		m.sb.WriteString("> " + EscapeMarkdown(line) + "\n")
+	}
+	return m
+}
+
+// Font 带颜色的文本 color为info(绿色)、comment(灰色)或warning(橙红色)
+func (m *MarkdownBuilder) Font(color FontColor, text string) *MarkdownBuilder {
+	switch color {
+	case FontColorInfo, FontColorComment, FontColorWarning:
+		m.sb.WriteString(`<font color="` + string(color) + `">` + EscapeMarkdown(text) + "</font>")
+	default:
+		// 不支持的颜色按普通文本展示
+		m.sb.WriteString(EscapeMarkdown(text))
+	}
+	return m
+}
+
+// Info 绿色文本
+func (m *MarkdownBuilder) Info(text string) *MarkdownBuilder {
+	return m.Font(FontColorInfo, text)
+}
+
+// Comment 灰色文本
+func (m *MarkdownBuilder) Comment(text string) *MarkdownBuilder {
+	return m.Font(FontColorComment, text)
+}
+
+// Warning 橙红色文本
+func (m *MarkdownBuilder) Warning(text string) *MarkdownBuilder {
+	return m.Font(FontColorWarning, text)
+}
+
+// Mention @群成员 userid中仅保留字母、数字及_-.@字符 防止注入其他标签
+func (m *MarkdownBuilder) Mention(userid string) *MarkdownBuilder {
+	userid = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '_' || r == '-' || r == '.' || r == '@':
+			return r
+		}
+		return -1
+	}, userid)
+	if userid != "" {
+		m.sb.WriteString("<@" + userid + ">")
+	}
+	return m
+}
+
+// NewLine 换行
+func (m *MarkdownBuilder) NewLine() *MarkdownBuilder {
+	m.sb.WriteString("\n")
+	return m
+}
+
+// lineStart 块级元素需从新的一行开始
+func (m *MarkdownBuilder) lineStart() {
+	if s := m.sb.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		m.sb.WriteString("\n")
+	}
+}
+
+// String 返回拼接好的markdown内容 可用于bot.ToMarkdownMsg(...)
+func (m *MarkdownBuilder) String() string {
+	return m.sb.String()
+}
@@ -0,0 +1,49 @@
+package wxrobot
+
+import "testing"
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"plain", "磁盘使用率 95%", "磁盘使用率 95%"},
+		{"font", `<font color="info">ok</font>`, `&lt;font color="info"&gt;ok&lt;/font&gt;`},
+		{"mention tag", "<@zhangsan>", "&lt;@\u200bzhangsan&gt;"},
+		{"at all", "@all 请处理", "@\u200ball 请处理"},
+		{"at after text", "通知 @zhangsan", "通知 @\u200bzhangsan"},
+		{"email", "ops@corp.com", "ops@corp.com"},
+		{"email with dot", "first.last+tag@corp.com", "first.last+tag@corp.com"},
+		{"entity", "a &amp; b", "a &amp;amp; b"},
+		{"markdown syntax", "**粗体** _x_ [a](b)", "**粗体** _x_ [a](b)"},
+		{"newline", "第一行\n第二行", "第一行 第二行"},
+	}
+	for _, tt := range tests {
+		if got := EscapeMarkdown(tt.text); got != tt.want {
+			t.Errorf("%s: EscapeMarkdown(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownBuilder(t *testing.T) {
+	tests := []struct {
+		name string
+		md   *MarkdownBuilder
+		want string
+	}{
+		{"text", NewMarkdown().Text("服务: <b>\n负责人: @all"), "服务: &lt;b&gt;\n负责人: @\u200ball"},
+		{"heading", NewMarkdown().Text("前言").Heading(9, "发布\n通知"), "前言\n###### 发布 通知\n"},
+		{"bold", NewMarkdown().Bold("<@zhangsan>"), "**&lt;@\u200bzhangsan&gt;**"},
+		{"link", NewMarkdown().Link("[告警] <详情>", "https://example.com/a b?(x)\n"), "[［告警］ &lt;详情&gt;](https://example.com/a%20b?%28x%29)"},
+		{"code", NewMarkdown().Code("a `b` <c>\nd"), "`a 'b' &lt;c&gt; d`"},
+		{"quote", NewMarkdown().Text("正文").Quote("第一行\n<font>第二行</font>"), "正文\n> 第一行\n> &lt;font&gt;第二行&lt;/font&gt;\n"},
+		{"font", NewMarkdown().Info("成功").Warning("<@lisi>"), "<font color=\"info\">成功</font><font color=\"warning\">&lt;@\u200blisi&gt;</font>"},
+		{"unknown color", NewMarkdown().Font("red", "x"), "x"},
+		{"mention", NewMarkdown().Mention("zhang.san@corp").Mention("><font>").Mention(""), "<@zhang.san@corp><@font>"},
+		{"raw", NewMarkdown().Raw("<@zhangsan>").NewLine(), "<@zhangsan>\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.md.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package wxrobot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxMarkdownV2Bytes markdown_v2内容最长不超过4096个字节 必须是utf8编码
+const MaxMarkdownV2Bytes = 4096
+
+// toMsgMarkdownV2 markdown_v2消息类型 支持表格、代码块、列表及图片 不支持<font>颜色及@群成员
+type toMsgMarkdownV2 struct {
+	toBaseMsg
+	Content string `json:"content"`
+}
+
+// PostId 小黑板帖子id，当前消息为小黑板回帖消息时带上，有且只有chatid指定了一个小黑板的时候生效
+func (tm *toMsgMarkdownV2) PostId(id string) *toMsgMarkdownV2 {
+	tm.postId = id
+	return tm
+}
+
+// ChatId
+/*
+会话id，支持最多传100个。
+可能是群聊会话，也可能是单聊会话或者小黑板会话，通过消息回调获得，也可以是userid。
+特殊的，当chatid为“@all_group”时，表示对所有群广播，为“@all_subscriber”时表示对订阅范围内员工广播单聊消息，
+为“@all_blackboard”时，表示对所有小黑板广播，为“@all”时，表示对所有群、所有订阅范围
+*/
+func (tm *toMsgMarkdownV2) ChatId(chatId ...string) *toMsgMarkdownV2 {
+	tm.chatId(chatId...)
+	return tm
+}
+
+// Visible
+// 该消息只有指定的群成员或小黑板成员可见（其他成员不可见），有且只有chatid指定了一个群或一个小黑板的时候生效
+func (tm *toMsgMarkdownV2) Visible(user ...string) *toMsgMarkdownV2 {
+	tm.visible(user...)
+	return tm
+}
+
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgMarkdownV2) TTL(ttl time.Duration) *toMsgMarkdownV2 {
+	tm.ttl = ttl
+	return tm
+}
+
+// Send 发送消息
+func (tm *toMsgMarkdownV2) Send() error {
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送
+func (tm *toMsgMarkdownV2) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.MarkdownV2 = tm
+	return cmsg.send(ctx)
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+func (tm *toMsgMarkdownV2) SendAsync() (*AsyncResult, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.MarkdownV2 = tm
+	return cmsg.sendAsync()
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+func (tm *toMsgMarkdownV2) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	if tm.Content == "" {
+		return invalidf("消息内容不能为空")
+	}
+	if len(tm.Content) > MaxMarkdownV2Bytes {
+		return fmt.Errorf("%w: 内容为%d字节, 不能超过%d字节", ErrContentTooLong, len(tm.Content), MaxMarkdownV2Bytes)
+	}
+	if !utf8.ValidString(tm.Content) {
+		return invalidf("markdown_v2内容必须是utf8编码")
+	}
+	return nil
+}
+
+// Payload 返回Send实际发送的JSON 不会发起网络请求
+func (tm *toMsgMarkdownV2) Payload() ([]byte, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.MarkdownV2 = tm
+	return cmsg.payload()
+}
+
+// tableCellEscaper 单元格中的|会被当作列分隔符 换行会截断表格
+var tableCellEscaper = strings.NewReplacer("|", "\\|", "\r\n", " ", "\n", " ", "\r", " ")
+
+// MarkdownV2Table 将rows渲染为markdown_v2表格 第一行为表头
+// 列数以最长的一行为准，不足的单元格留空
+func MarkdownV2Table(rows [][]string) string {
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var cols int
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	if cols == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	writeRow := func(row []string) {
+		sb.WriteString("|")
+		for i := 0; i < cols; i++ {
+			var cell string
+			if i < len(row) {
+				cell = tableCellEscaper.Replace(strings.TrimSpace(row[i]))
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+		sb.WriteString("\n")
+	}
+
+	writeRow(rows[0])
+	sb.WriteString("|" + strings.Repeat(" --- |", cols) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return sb.String()
+}
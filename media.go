@@ -0,0 +1,266 @@
+package wxrobot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MediaType 上传的媒体文件类型
+type MediaType string
+
+const (
+	MediaTypeFile  MediaType = "file"  // 普通文件
+	MediaTypeVoice MediaType = "voice" // 语音 仅支持amr格式
+)
+
+const (
+	MinFileBytes = 5        // 上传文件最小5B
+	MaxFileBytes = 20 << 20 // 上传文件最大20M
+
+	// MediaExpiration 上传的媒体文件media_id有效期为3天
+	MediaExpiration = 72 * time.Hour
+	// mediaCacheMargin 缓存的media_id在过期前提前失效 避免发送时刚好过期
+	mediaCacheMargin = time.Hour
+)
+
+var ErrMediaSize = errors.New("wxrobot: media size out of range")
+
+// Media 上传后的媒体文件
+type Media struct {
+	ID        string    // 企业微信返回的media_id 可用于发送文件消息
+	Type      MediaType // 媒体文件类型
+	CreatedAt time.Time // 上传时间
+}
+
+// ExpiresAt media_id的过期时间
+func (m *Media) ExpiresAt() time.Time {
+	return m.CreatedAt.Add(MediaExpiration)
+}
+
+// mediaCache 按文件内容hash缓存上传过的media_id 相同内容在有效期内只上传一次
+type mediaCache struct {
+	mu    sync.Mutex
+	items map[string]*Media
+}
+
+func (c *mediaCache) get(key string) *Media {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(m.ExpiresAt().Add(-mediaCacheMargin)) {
+		delete(c.items, key)
+		return nil
+	}
+	return m
+}
+
+// put 缓存media_id dry-run模式下虚拟的media_id不缓存 避免关闭dry-run后被真实发送
+func (c *mediaCache) put(key string, m *Media) {
+	if strings.HasPrefix(m.ID, dryRunMediaPrefix) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[string]*Media)
+	}
+	c.items[key] = m
+}
+
+// UploadMedia 上传媒体文件 返回的Media.ID可用于ToFileMsg().MediaId(...)发送
+// 相同内容的文件在media_id有效期内会复用缓存，不会重复上传；上传失败时返回 *APIError 等错误
+func (r *bot) UploadMedia(ctx context.Context, name string, reader io.Reader, mediaType MediaType) (*Media, error) {
+	bts, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return r.uploadMedia(ctx, name, bts, mediaType)
+}
+
+func (r *bot) uploadMedia(ctx context.Context, name string, bts []byte, mediaType MediaType) (*Media, error) {
+	if err := checkMediaSize(name, int64(len(bts)), mediaType); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(bts)
+	key := r.uploadUrl + "|" + string(mediaType) + "|" + hex.EncodeToString(sum[:])
+	if m := r.mediaCache.get(key); m != nil {
+		wxRobot.logger.Debug("reuse media_id", m.ID, "for", name)
+		return m, nil
+	}
+
+	var res *upLoadRes
+	err := r.withRetry(ctx, "上传文件", func() (err error) {
+		res, err = r.upload(ctx, name, bytes.NewReader(bts), mediaType)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := res.media(mediaType)
+	r.mediaCache.put(key, m)
+	return m, nil
+}
+
+// uploadMediaFile 流式上传本地文件 先流式计算文件hash以复用缓存 重试时重新打开文件
+func (r *bot) uploadMediaFile(ctx context.Context, path string, mediaType MediaType) (*Media, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	key := r.uploadUrl + "|" + string(mediaType) + "|" + hex.EncodeToString(h.Sum(nil))
+	if m := r.mediaCache.get(key); m != nil {
+		wxRobot.logger.Debug("reuse media_id", m.ID, "for", path)
+		return m, nil
+	}
+
+	var res *upLoadRes
+	err = r.withRetry(ctx, "上传文件", func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		res, err = r.upload(ctx, filepath.Base(path), f, mediaType)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := res.media(mediaType)
+	r.mediaCache.put(key, m)
+	return m, nil
+}
+
+// uploadMediaReader 流式上传reader中的内容 reader只能读取一次 因此不会重试也不会缓存
+func (r *bot) uploadMediaReader(ctx context.Context, name string, reader io.Reader, mediaType MediaType) (*Media, error) {
+	res, err := r.upload(ctx, name, reader, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	return res.media(mediaType), nil
+}
+
+func (u *upLoadRes) media(mediaType MediaType) *Media {
+	m := &Media{ID: u.MediaId, Type: MediaType(u.Type), CreatedAt: time.Unix(u.CreatedAt, 0)}
+	if m.Type == "" {
+		m.Type = mediaType
+	}
+	if u.CreatedAt == 0 {
+		m.CreatedAt = time.Now()
+	}
+	return m
+}
+
+// checkMediaSize 校验已知大小的文件是否满足大小要求 普通文件5B~20M 语音5B~2M
+func checkMediaSize(name string, size int64, mediaType MediaType) error {
+	maxSize, limit := int64(MaxFileBytes), "20M"
+	if mediaType == MediaTypeVoice {
+		maxSize, limit = MaxVoiceBytes, "2M"
+	}
+	if size < MinFileBytes || size > maxSize {
+		return fmt.Errorf("%w: 文件[%s]大小为%d字节, 要求在5B~%s之间", ErrMediaSize, name, size, limit)
+	}
+	return nil
+}
+
+// mediaPartHeader 上传文件的multipart头 Content-Type根据文件扩展名确定
+func mediaPartHeader(filename string) textproto.MIMEHeader {
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file1"; filename="%s"`, quoteEscaper.Replace(filename)))
+	h.Set("Content-Type", contentType)
+	return h
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// FileFromReader 流式发送reader中的文件内容 name为文件名 用于确定文件类型
+// size为文件大小 已知时会提前校验5B~20M的限制，未知时传-1；reader只能读取一次，上传失败时不会重试
+func (tm *toMsgFile) FileFromReader(name string, reader io.Reader, size int64) *toMsgFile {
+	tm.reset()
+	if size >= 0 {
+		tm.err = checkMediaSize(name, size, MediaTypeFile)
+	}
+	tm.fileName = name
+	tm.fileReader = reader
+	return tm
+}
+
+// FileFromPath 流式发送本地文件 要求文件大小在5B~20M之间
+func (tm *toMsgFile) FileFromPath(path string) *toMsgFile {
+	tm.reset()
+	fi, err := os.Stat(path)
+	if err != nil {
+		tm.err = err
+		return tm
+	}
+	tm.err = checkMediaSize(path, fi.Size(), MediaTypeFile)
+	tm.fileName = filepath.Base(path)
+	tm.filePath = path
+	return tm
+}
+
+func (tm *toMsgFile) reset() {
+	tm.MediaID = ""
+	tm.fileName = ""
+	tm.fileContent = nil
+	tm.filePath = ""
+	tm.fileReader = nil
+	tm.err = nil
+}
+
+// check 发送前检查是否设置了要发送的文件
+func (tm *toMsgFile) check() error {
+	if tm.err != nil {
+		return tm.err
+	}
+	if tm.MediaID == "" && tm.fileContent == nil && tm.filePath == "" && tm.fileReader == nil {
+		return fmt.Errorf("请先调用File(...)或MediaId(...)方法设置要发送的文件")
+	}
+	return nil
+}
+
+// uploadMedia 按文件来源上传文件
+func (tm *toMsgFile) uploadMedia(ctx context.Context) (*Media, error) {
+	switch {
+	case tm.filePath != "":
+		return tm.bot.uploadMediaFile(ctx, tm.filePath, MediaTypeFile)
+	case tm.fileReader != nil:
+		return tm.bot.uploadMediaReader(ctx, tm.fileName, tm.fileReader, MediaTypeFile)
+	default:
+		return tm.bot.uploadMedia(ctx, tm.fileName, tm.fileContent, MediaTypeFile)
+	}
+}
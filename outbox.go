@@ -0,0 +1,306 @@
+package wxrobot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	outboxPendingExt = ".json"   // 待发送的消息
+	outboxFailedExt  = ".failed" // 永久错误或过期的消息 保留在目录中供排查 不再重放
+)
+
+// defaultOutboxReplayInterval 默认每隔多久重放一次发送失败的消息
+const defaultOutboxReplayInterval = time.Minute
+
+// outboxReplayPolicy 重放待发送消息时的退避策略
+var outboxReplayPolicy = RetryPolicy{Backoff: time.Second, MaxBackoff: time.Minute, Jitter: 0.2}
+
+// outbox 待发送消息的磁盘持久化
+// 消息在发送前写入磁盘，发送成功后才删除；网络错误、5xx等临时错误的消息保留在目录中，
+// 由后台定时重放及服务重启后重放，永久错误或过期的消息标记为失败；入队失败时调用方已收到错误，消息不会被重放
+type outbox struct {
+	dir  string
+	seq  uint64
+	done chan struct{} // 重新调用Outbox(...)时关闭 停止后台重放
+
+	mu       sync.Mutex
+	inflight map[string]bool // 正在发送或重放的消息 重放时跳过
+}
+
+// outboxMedia 需要在发送前上传的文件
+type outboxMedia struct {
+	Field   string `json:"field"` // 上传后media_id写入payload的字段 同时也是上传的MediaType 如file
+	Name    string `json:"name"`
+	Content []byte `json:"content,omitempty"`
+	Path    string `json:"path,omitempty"` // FileFromPath(...)发送的文件只记录路径
+}
+
+// outboxEntry 持久化的一条待发送消息
+type outboxEntry struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Payload   json.RawMessage `json:"payload"` // buildCommonMsg构建的消息JSON
+	Media     *outboxMedia    `json:"media,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"` // 延迟消息在发送时去重 outbox重放时已在首次发送前记录过
+}
+
+// Outbox 开启消息的磁盘持久化 dir为存放待发送消息的目录 需在WebhookURL(...)之后调用
+// 开启时会在后台重放目录中上次未发送成功的消息，之后每隔replayInterval(默认1分钟)重放临时错误发送失败的消息，
+// replayInterval<=0时只在开启时重放一次；同步发送遇到临时错误时消息同样会被重放，调用方无需自行重试
+func (r *bot) Outbox(dir string, replayInterval ...time.Duration) *bot {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("机器人[%s]创建outbox目录[%s]失败: %v", r.name, dir, err))
+		return r
+	}
+
+	interval := defaultOutboxReplayInterval
+	if len(replayInterval) > 0 {
+		interval = replayInterval[0]
+	}
+	if r.outbox != nil {
+		close(r.outbox.done)
+	}
+	ob := &outbox{dir: dir, done: make(chan struct{}), inflight: make(map[string]bool)}
+	r.outbox = ob
+	go r.replayOutboxLoop(ob, interval)
+	return r
+}
+
+// replayOutboxLoop 后台重放outbox中的消息 直到outbox被替换
+func (r *bot) replayOutboxLoop(ob *outbox, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-ob.done
+		cancel()
+	}()
+
+	for {
+		if err := r.replayOutbox(ctx, ob); err != nil && ctx.Err() == nil {
+			wxRobot.logger.Error(fmt.Sprintf("机器人[%s]重放outbox失败: %v", r.name, err))
+		}
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ReplayOutbox 按写入顺序重放outbox中未发送成功且不在发送中的消息 运行期间可随时调用
+// 临时错误会按退避策略一直重试直到ctx结束，永久错误的消息会被标记为失败不再重放
+func (r *bot) ReplayOutbox(ctx context.Context) error {
+	if r.outbox == nil {
+		return nil
+	}
+	return r.replayOutbox(ctx, r.outbox)
+}
+
+func (r *bot) replayOutbox(ctx context.Context, ob *outbox) error {
+	entries, err := ob.claim()
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		for attempt := 1; ; attempt++ {
+			err = r.deliverEntry(ctx, entry)
+			if err != nil && ctx.Err() != nil {
+				break
+			}
+			if err == nil || !isRetryable(err) {
+				ob.finish(entry.ID, err)
+				break
+			}
+
+			wait := outboxReplayPolicy.backoff(attempt)
+			wxRobot.logger.Warn(fmt.Sprintf("机器人[%s]重放消息[%s]失败，%v后重试, err: %v", r.name, entry.ID, wait, err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+				continue
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			// 未重放完的消息留待下次重放
+			for _, e := range entries[i:] {
+				ob.unclaim(e.ID)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// deliverEntry 发送一条持久化的消息
+func (r *bot) deliverEntry(ctx context.Context, entry *outboxEntry) error {
+	payload := entry.Payload
+	if entry.Media != nil {
+		var media *Media
+		var err error
+		if entry.Media.Path != "" {
+			media, err = r.uploadMediaFile(ctx, entry.Media.Path, MediaType(entry.Media.Field))
+		} else {
+			media, err = r.uploadMedia(ctx, entry.Media.Name, entry.Media.Content, MediaType(entry.Media.Field))
+		}
+		if err != nil {
+			return err
+		}
+
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(payload, &fields); err != nil {
+			return err
+		}
+		fields[entry.Media.Field], _ = json.Marshal(map[string]string{"media_id": media.ID})
+		if payload, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+
+	return r.withRetry(ctx, "发送消息", func() error {
+		return r.post(ctx, payload)
+	})
+}
+
+func newOutboxEntry(tc *toCommonMsg) (*outboxEntry, error) {
+	payload, err := tc.payload()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &outboxEntry{CreatedAt: time.Now(), Payload: payload, IdempotencyKey: tc.idempotencyKey}
+	if f := tc.File; f != nil && f.MediaID == "" {
+		// reader只能读取一次 持久化时需要先读入内存
+		if f.fileReader != nil {
+			if f.fileContent, err = ioutil.ReadAll(f.fileReader); err != nil {
+				return nil, err
+			}
+			f.fileReader = nil
+		}
+		entry.Media = &outboxMedia{Field: "file", Name: f.fileName, Content: f.fileContent, Path: f.filePath}
+	}
+	if v := tc.Voice; v != nil && v.MediaID == "" {
+		entry.Media = &outboxMedia{Field: "voice", Name: v.fileName, Content: v.fileContent}
+	}
+	return entry, nil
+}
+
+// save 写入一条消息 先写临时文件再重命名 避免重放时读到不完整的文件
+func (ob *outbox) save(entry *outboxEntry) error {
+	entry.ID = fmt.Sprintf("%020d-%06d", entry.CreatedAt.UnixNano(), atomic.AddUint64(&ob.seq, 1)%1000000)
+	bt, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(ob.dir, entry.ID+outboxPendingExt)
+	if err = ioutil.WriteFile(path+".tmp", bt, 0o644); err != nil {
+		return err
+	}
+	ob.mu.Lock()
+	ob.inflight[entry.ID] = true
+	ob.mu.Unlock()
+	return os.Rename(path+".tmp", path)
+}
+
+// finish 消息发送结束 成功时删除；临时错误时保留等待重放；永久错误或过期时标记为失败保留在目录中供排查 不再重放
+func (ob *outbox) finish(id string, err error) {
+	defer ob.unclaim(id)
+
+	path := filepath.Join(ob.dir, id+outboxPendingExt)
+	switch {
+	case err == nil:
+		err = os.Remove(path)
+	case isRetryable(err):
+		wxRobot.logger.Warn(fmt.Sprintf("outbox消息[%s]发送失败, 等待重放: %v", id, err))
+		return
+	default:
+		wxRobot.logger.Error(fmt.Sprintf("outbox消息[%s]发送失败, 已标记为失败: %v", id, err))
+		err = os.Rename(path, filepath.Join(ob.dir, id+outboxFailedExt))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		wxRobot.logger.Error(fmt.Sprintf("outbox消息[%s]清理失败: %v", id, err))
+	}
+}
+
+// remove 删除消息 消息未发送且调用方已收到错误时使用
+func (ob *outbox) remove(id string) {
+	defer ob.unclaim(id)
+	if err := os.Remove(filepath.Join(ob.dir, id+outboxPendingExt)); err != nil && !os.IsNotExist(err) {
+		wxRobot.logger.Error(fmt.Sprintf("outbox消息[%s]清理失败: %v", id, err))
+	}
+}
+
+func (ob *outbox) unclaim(id string) {
+	ob.mu.Lock()
+	delete(ob.inflight, id)
+	ob.mu.Unlock()
+}
+
+// finishOutbox 发送结束(成功、失败或过期)后更新持久化的消息
+func (tc *toCommonMsg) finishOutbox(err error) {
+	if tc.outboxID != "" {
+		tc.bot.outbox.finish(tc.outboxID, err)
+	}
+}
+
+// discard 删除持久化的消息 入队失败时调用方已收到错误 由调用方决定是否重试
+func (tc *toCommonMsg) discard() {
+	if tc.outboxID != "" {
+		tc.bot.outbox.remove(tc.outboxID)
+	}
+}
+
+// claim 返回不在发送中的待发送消息 按写入顺序排序 返回的消息标记为发送中直到finish或unclaim
+func (ob *outbox) claim() ([]*outboxEntry, error) {
+	files, err := ioutil.ReadDir(ob.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	var entries []*outboxEntry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), outboxPendingExt) {
+			continue
+		}
+
+		bt, err := ioutil.ReadFile(filepath.Join(ob.dir, f.Name()))
+		if os.IsNotExist(err) {
+			continue // 读取目录后发送成功被删除
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := new(outboxEntry)
+		if err = json.Unmarshal(bt, entry); err != nil {
+			wxRobot.logger.Error(fmt.Sprintf("outbox消息[%s]解析失败: %v", f.Name(), err))
+			continue
+		}
+		if ob.inflight[entry.ID] {
+			continue
+		}
+		ob.inflight[entry.ID] = true
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, nil
+}
@@ -0,0 +1,153 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// outboxFiles 返回outbox目录中的文件扩展名 按文件名排序
+func outboxFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exts []string
+	for _, f := range files {
+		exts = append(exts, filepath.Ext(f.Name()))
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+func TestOutboxReplay(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	dir := t.TempDir()
+
+	// 模拟进程在写入消息后、发送前退出
+	ob := &outbox{dir: dir, inflight: make(map[string]bool)}
+	crashed := Bot("outbox-test-crashed").WebhookURL(srv.webhook())
+	for _, content := range []string{"first", "second", "third"} {
+		msg := crashed.ToTextMsg(content)
+		cmsg := msg.buildCommonMsg()
+		cmsg.Text = msg
+		entry, err := newOutboxEntry(cmsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ob.save(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := outboxFiles(t, dir); len(files) != 3 {
+		t.Fatalf("outbox files = %v, want 3 pending", files)
+	}
+
+	// 重启后按写入顺序重放
+	Bot("outbox-test-restarted").WebhookURL(srv.webhook()).Outbox(dir, 0)
+	if !waitFor(2*time.Second, func() bool { return len(outboxFiles(t, dir)) == 0 }) {
+		t.Fatalf("outbox files after replay = %v", outboxFiles(t, dir))
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[first second third]" {
+		t.Errorf("replayed %s, want [first second third]", got)
+	}
+}
+
+func TestOutboxSend(t *testing.T) {
+	srv := newRecordServer(t, func(n int, msg recordedMsg) string {
+		switch {
+		case msg.Content == "bad":
+			return `{"errcode":93000,"errmsg":"invalid webhook url"}`
+		case msg.Content == "flaky" && n == 2:
+			return "503"
+		}
+		return `{"errcode":0}`
+	})
+	dir := t.TempDir()
+	b := Bot("outbox-test-send").WebhookURL(srv.webhook()).Outbox(dir, 20*time.Millisecond)
+
+	// 发送成功后删除
+	if err := b.ToTextMsg("ok").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if files := outboxFiles(t, dir); len(files) != 0 {
+		t.Errorf("outbox files after success = %v", files)
+	}
+
+	// 临时错误时保留 由后台定时重放
+	if err := b.ToTextMsg("flaky").Send(); err == nil {
+		t.Fatal("expected 503 error")
+	}
+	if !waitFor(2*time.Second, func() bool { return len(outboxFiles(t, dir)) == 0 }) {
+		t.Fatalf("flaky message was not replayed, outbox files = %v", outboxFiles(t, dir))
+	}
+
+	// 永久错误时标记为失败 不再重放
+	if err := b.ToTextMsg("bad").Send(); !errors.Is(err, ErrInvalidWebhookKey) {
+		t.Fatalf("err = %v, want ErrInvalidWebhookKey", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if files := outboxFiles(t, dir); strings.Join(files, ",") != outboxFailedExt {
+		t.Errorf("outbox files after permanent error = %v, want one %s", files, outboxFailedExt)
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[ok flaky]" {
+		t.Errorf("received %s, want [ok flaky]", got)
+	}
+}
+
+func TestOutboxAsync(t *testing.T) {
+	release := make(chan struct{})
+	srv := newRecordServer(t, func(n int, msg recordedMsg) string {
+		if n == 1 {
+			<-release
+		}
+		return `{"errcode":0}`
+	})
+	dir := t.TempDir()
+	b := Bot("outbox-test-async").WebhookURL(srv.webhook()).Outbox(dir, 0).Async(AsyncConfig{Workers: 1, QueueSize: 1})
+	defer b.StopAsync(context.Background())
+
+	first, err := b.ToTextMsg("first").SendAsync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool { return len(b.currentQueue().shards[0]) == 0 }) {
+		t.Fatal("worker did not pick up the first message")
+	}
+	expired, err := b.ToTextMsg("expired").TTL(time.Millisecond).SendAsync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 队列已满时调用方已收到错误 消息不会被重放
+	if _, err = b.ToTextMsg("full").SendAsync(); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v, want ErrQueueFull", err)
+	}
+	// 发送中的消息不会被重复重放
+	if err = b.ReplayOutbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = first.Wait(ctx); err != nil {
+		t.Errorf("first: %v", err)
+	}
+	if err = expired.Wait(ctx); !errors.Is(err, ErrMessageExpired) {
+		t.Errorf("expired: err = %v, want ErrMessageExpired", err)
+	}
+
+	if files := outboxFiles(t, dir); strings.Join(files, ",") != outboxFailedExt {
+		t.Errorf("outbox files = %v, want the expired message marked as failed", files)
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[first]" {
+		t.Errorf("received %s, want [first]", got)
+	}
+}
@@ -0,0 +1,208 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAsyncDisabled  = errors.New("wxrobot: async mode is not enabled, please first call Async(...)")
+	ErrQueueFull      = errors.New("wxrobot: async queue is full")
+	ErrQueueClosed    = errors.New("wxrobot: async queue is closed")
+	ErrMessageExpired = errors.New("wxrobot: message expired before delivery")
+)
+
+// AsyncConfig 异步发送配置
+type AsyncConfig struct {
+	Workers   int           // 并发发送的worker数 默认4
+	QueueSize int           // 队列容量 队列满时Send返回 ErrQueueFull 默认1000
+	TTL       time.Duration // 消息在队列中的默认有效期 过期后丢弃不再发送 0表示不过期
+}
+
+// AsyncResult 异步发送的结果
+type AsyncResult struct {
+	done chan struct{}
+	err  error
+}
+
+// Done 发送完成(成功、失败或过期丢弃)时关闭
+func (a *AsyncResult) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err 返回发送结果 需在Done关闭后调用
+func (a *AsyncResult) Err() error {
+	return a.err
+}
+
+// Wait 等待发送完成并返回发送结果
+func (a *AsyncResult) Wait(ctx context.Context) error {
+	select {
+	case <-a.done:
+		return a.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *AsyncResult) finish(err error) {
+	a.err = err
+	close(a.done)
+}
+
+type asyncJob struct {
+	msg      *toCommonMsg
+	expireAt time.Time
+	result   *AsyncResult
+}
+
+// asyncQueue 有界的异步发送队列
+// 按chatid分片到固定的worker，不同会话之间并发发送，同一会话内保持先进先出
+type asyncQueue struct {
+	mu     sync.RWMutex
+	closed bool
+	ttl    time.Duration
+	shards []chan *asyncJob
+	wg     sync.WaitGroup
+}
+
+// Async 开启机器人的异步发送模式
+// 开启后Send/SendContext只将消息放入队列即返回，SendAsync可获取发送结果
+func (r *bot) Async(cfg AsyncConfig) *bot {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	size := cfg.QueueSize / cfg.Workers
+	if size < 1 {
+		size = 1
+	}
+
+	q := &asyncQueue{ttl: cfg.TTL, shards: make([]chan *asyncJob, cfg.Workers)}
+	for i := range q.shards {
+		q.shards[i] = make(chan *asyncJob, size)
+		q.wg.Add(1)
+		go q.work(q.shards[i])
+	}
+
+	r.queueMu.Lock()
+	old := r.queue
+	r.queue = q
+	r.queueMu.Unlock()
+
+	// 重复调用时关闭之前的队列 已入队的消息由原有的worker发送完后退出
+	if old != nil {
+		old.close()
+	}
+	return r
+}
+
+// StopAsync 关闭异步发送模式 等待队列中的消息发送完成或ctx结束
+func (r *bot) StopAsync(ctx context.Context) error {
+	r.queueMu.Lock()
+	q := r.queue
+	r.queue = nil
+	r.queueMu.Unlock()
+	if q == nil {
+		return nil
+	}
+
+	q.close()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// currentQueue 当前的异步发送队列 未开启异步模式时返回nil
+func (r *bot) currentQueue() *asyncQueue {
+	r.queueMu.RLock()
+	defer r.queueMu.RUnlock()
+	return r.queue
+}
+
+// close 关闭队列 不再接收新消息 worker发送完已入队的消息后退出
+func (q *asyncQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		for _, shard := range q.shards {
+			close(shard)
+		}
+	}
+}
+
+// enqueue 将消息放入队列 队列满时不阻塞直接返回 ErrQueueFull
+func (q *asyncQueue) enqueue(msg *toCommonMsg) (*AsyncResult, error) {
+	job := &asyncJob{msg: msg, result: &AsyncResult{done: make(chan struct{})}}
+	ttl := q.ttl
+	if msg.ttl > 0 {
+		ttl = msg.ttl
+	}
+	if ttl > 0 {
+		job.expireAt = time.Now().Add(ttl)
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(msg.ChatID))
+	shard := q.shards[h.Sum32()%uint32(len(q.shards))]
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	select {
+	case shard <- job:
+		return job.result, nil
+	default:
+		return nil, ErrQueueFull
+	}
+}
+
+func (q *asyncQueue) work(jobs <-chan *asyncJob) {
+	defer q.wg.Done()
+	for job := range jobs {
+		err := job.run()
+		job.msg.finishOutbox(err)
+		job.result.finish(err)
+	}
+}
+
+func (job *asyncJob) run() error {
+	ctx := context.Background()
+	if !job.expireAt.IsZero() {
+		if time.Now().After(job.expireAt) {
+			wxRobot.logger.Warn(fmt.Sprintf("机器人[%s]消息已过期, 丢弃发送 chatid:%s", job.msg.name, job.msg.ChatID))
+			job.msg.release()
+			return ErrMessageExpired
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, job.expireAt)
+		defer cancel()
+	}
+
+	err := job.msg.deliver(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrMessageExpired
+	}
+	if err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("机器人[%s]异步发送消息失败 chatid:%s, err: %v", job.msg.name, job.msg.ChatID, err))
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package wxrobot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedMsg 测试服务器收到的文本消息
+type recordedMsg struct {
+	ChatID  string
+	Content string
+}
+
+// recordServer 记录收到的文本消息的webhook服务器
+type recordServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests int
+	msgs     []recordedMsg // 成功的消息
+}
+
+// newRecordServer respond返回第n(从1开始)次请求的响应 数字开头表示HTTP状态码 respond为nil时全部返回成功
+func newRecordServer(t *testing.T, respond func(n int, msg recordedMsg) string) *recordServer {
+	s := new(recordServer)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ChatID string `json:"chatid"`
+			Text   struct {
+				Content string `json:"content"`
+			} `json:"text"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		msg := recordedMsg{ChatID: payload.ChatID, Content: payload.Text.Content}
+
+		s.mu.Lock()
+		s.requests++
+		n := s.requests
+		s.mu.Unlock()
+
+		resp := `{"errcode":0}`
+		if respond != nil {
+			resp = respond(n, msg)
+		}
+		var status int
+		if _, err := fmt.Sscanf(resp, "%d", &status); err == nil {
+			w.WriteHeader(status)
+			return
+		}
+		if resp == `{"errcode":0}` {
+			s.mu.Lock()
+			s.msgs = append(s.msgs, msg)
+			s.mu.Unlock()
+		}
+		_, _ = w.Write([]byte(resp))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordServer) webhook() string {
+	return s.URL + "/send?key=test"
+}
+
+// messages 成功发送的消息
+func (s *recordServer) messages() []recordedMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedMsg(nil), s.msgs...)
+}
+
+// contents 成功发送的消息内容
+func (s *recordServer) contents() []string {
+	var contents []string
+	for _, msg := range s.messages() {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+// waitFor 等待cond成立 超时后返回false
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+func TestAsyncQueueOrder(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	b := Bot("queue-test-order").WebhookURL(srv.webhook()).Async(AsyncConfig{Workers: 4, QueueSize: 400})
+	defer b.StopAsync(context.Background())
+
+	chats := []string{"chat-a", "chat-b", "chat-c"}
+	for i := 0; i < 50; i++ {
+		for _, chat := range chats {
+			if err := b.ToTextMsg(fmt.Sprintf("%d", i)).ChatId(chat).Send(); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+		}
+	}
+	if err := b.StopAsync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一会话内先进先出
+	next := make(map[string]int)
+	for _, msg := range srv.messages() {
+		if want := fmt.Sprintf("%d", next[msg.ChatID]); msg.Content != want {
+			t.Fatalf("chat %s received %s, want %s", msg.ChatID, msg.Content, want)
+		}
+		next[msg.ChatID]++
+	}
+	for _, chat := range chats {
+		if next[chat] != 50 {
+			t.Errorf("chat %s received %d messages, want 50", chat, next[chat])
+		}
+	}
+}
+
+func TestAsyncQueueTTL(t *testing.T) {
+	release := make(chan struct{})
+	srv := newRecordServer(t, func(n int, msg recordedMsg) string {
+		if n == 1 {
+			<-release // 第一条消息阻塞worker
+		}
+		return `{"errcode":0}`
+	})
+	b := Bot("queue-test-ttl").WebhookURL(srv.webhook()).Async(AsyncConfig{Workers: 1, TTL: time.Hour})
+	defer b.StopAsync(context.Background())
+
+	first, err := b.ToTextMsg("first").SendAsync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := b.ToTextMsg("expired").TTL(20 * time.Millisecond).SendAsync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := b.ToTextMsg("kept").SendAsync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = first.Wait(ctx); err != nil {
+		t.Errorf("first: %v", err)
+	}
+	if err = expired.Wait(ctx); !errors.Is(err, ErrMessageExpired) {
+		t.Errorf("expired: err = %v, want ErrMessageExpired", err)
+	}
+	if err = kept.Wait(ctx); err != nil {
+		t.Errorf("kept: %v", err)
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[first kept]" {
+		t.Errorf("received %s, want [first kept]", got)
+	}
+}
+
+func TestAsyncQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	srv := newRecordServer(t, func(n int, msg recordedMsg) string {
+		<-release
+		return `{"errcode":0}`
+	})
+	b := Bot("queue-test-full").WebhookURL(srv.webhook()).Async(AsyncConfig{Workers: 1, QueueSize: 1})
+
+	// 第一条被worker取出后阻塞 第二条占满队列
+	if err := b.ToTextMsg("1").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool { return len(b.currentQueue().shards[0]) == 0 }) {
+		t.Fatal("worker did not pick up the first message")
+	}
+	if err := b.ToTextMsg("2").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ToTextMsg("3").Send(); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Send on full queue: err = %v, want ErrQueueFull", err)
+	}
+
+	close(release)
+	if err := b.StopAsync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ToTextMsg("4").SendAsync(); !errors.Is(err, ErrAsyncDisabled) {
+		t.Errorf("SendAsync after StopAsync: err = %v, want ErrAsyncDisabled", err)
+	}
+	if got := fmt.Sprint(srv.contents()); got != "[1 2]" {
+		t.Errorf("received %s, want [1 2]", got)
+	}
+}
+
+func TestAsyncRestart(t *testing.T) {
+	srv := newRecordServer(t, nil)
+	b := Bot("queue-test-restart").WebhookURL(srv.webhook()).Async(AsyncConfig{Workers: 2})
+	old := b.currentQueue()
+
+	// 重复调用Async时关闭之前的队列 之前的worker退出
+	b.Async(AsyncConfig{Workers: 2})
+	done := make(chan struct{})
+	go func() {
+		old.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("workers of the previous queue are still running")
+	}
+
+	// 发送与StopAsync并发时不能有数据竞争 关闭后的消息同步发送
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := b.ToTextMsg(fmt.Sprintf("%d", i)).Send(); err != nil && !errors.Is(err, ErrQueueClosed) {
+				t.Errorf("Send: %v", err)
+			}
+		}(i)
+	}
+	if err := b.StopAsync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if b.currentQueue() != nil {
+		t.Error("queue should be nil after StopAsync")
+	}
+}
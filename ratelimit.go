@@ -0,0 +1,119 @@
+package wxrobot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 企业微信群机器人的推送频率限制 每个webhook每分钟最多20条
+const (
+	DefaultRateLimit  = 20
+	DefaultRatePeriod = time.Minute
+)
+
+// RateUsage 限流器的当前使用情况
+type RateUsage struct {
+	Limit     int           // 每个周期允许发送的消息数
+	Period    time.Duration // 限流周期
+	Available int           // 当前可立即发送的消息数
+	Used      int           // 当前周期内已占用的额度
+	// ResetIn 额度完全恢复所需的时间
+	ResetIn time.Duration
+}
+
+// rateLimiter 令牌桶限流器 按webhookURL区分令牌桶
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	period  time.Duration
+	wait    bool // 额度不足时是否等待 false时直接返回错误
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimit 设置机器人的客户端限流 每个webhook在period内最多发送limit条消息
+// wait为true时额度不足会等待(受ctx控制)，为false时直接返回 ErrRateLimitExceeded
+// limit小于等于0时关闭限流
+func (r *bot) RateLimit(limit int, period time.Duration, wait bool) *bot {
+	if limit <= 0 || period <= 0 {
+		r.limiter = nil
+		return r
+	}
+
+	r.limiter = &rateLimiter{limit: limit, period: period, wait: wait, buckets: make(map[string]*tokenBucket)}
+	return r
+}
+
+// RateUsage 返回当前webhook的限流使用情况 未开启限流时返回零值
+// 可用于在额度紧张时合并消息后再发送
+func (r *bot) RateUsage() RateUsage {
+	if r.limiter == nil {
+		return RateUsage{}
+	}
+	return r.limiter.usage(r.webhookURL)
+}
+
+// refill 按流逝的时间补充令牌 调用方需持有锁
+func (l *rateLimiter) refill(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit), last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(l.period) * float64(l.limit)
+	if b.tokens > float64(l.limit) {
+		b.tokens = float64(l.limit)
+	}
+	b.last = now
+	return b
+}
+
+func (l *rateLimiter) usage(key string) RateUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, time.Now())
+	missing := float64(l.limit) - b.tokens
+	return RateUsage{
+		Limit:     l.limit,
+		Period:    l.period,
+		Available: int(b.tokens),
+		Used:      l.limit - int(b.tokens),
+		ResetIn:   time.Duration(missing / float64(l.limit) * float64(l.period)),
+	}
+}
+
+// acquire 获取一个令牌
+func (l *rateLimiter) acquire(ctx context.Context, key string) error {
+	for {
+		l.mu.Lock()
+		b := l.refill(key, time.Now())
+		if b.tokens >= 1 {
+			b.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / float64(l.limit) * float64(l.period))
+		l.mu.Unlock()
+
+		if !l.wait {
+			return fmt.Errorf("%w: 客户端限流 %d条/%v, 需等待%v", ErrRateLimitExceeded, l.limit, l.period, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
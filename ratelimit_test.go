@@ -0,0 +1,91 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(limit int, period time.Duration, wait bool) *rateLimiter {
+	return &rateLimiter{limit: limit, period: period, wait: wait, buckets: make(map[string]*tokenBucket)}
+}
+
+func TestRateLimiterAcquire(t *testing.T) {
+	l := newTestLimiter(3, time.Minute, false)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := l.acquire(ctx, "a"); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if err := l.acquire(ctx, "a"); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("acquire over limit = %v, want ErrRateLimitExceeded", err)
+	}
+	// 不同webhook的额度互不影响
+	if err := l.acquire(ctx, "b"); err != nil {
+		t.Errorf("acquire other key: %v", err)
+	}
+
+	// 经过1/3个周期补充一个令牌
+	l.buckets["a"].last = l.buckets["a"].last.Add(-20 * time.Second)
+	if err := l.acquire(ctx, "a"); err != nil {
+		t.Errorf("acquire after refill: %v", err)
+	}
+	if err := l.acquire(ctx, "a"); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("acquire after one refill = %v, want ErrRateLimitExceeded", err)
+	}
+
+	// 补充的令牌不超过limit
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Hour)
+	if u := l.usage("a"); u.Available != 3 || u.Used != 0 || u.ResetIn != 0 {
+		t.Errorf("usage after long idle = %+v", u)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newTestLimiter(10, 100*time.Millisecond, true)
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := l.acquire(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	if err := l.acquire(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 5*time.Millisecond {
+		t.Errorf("acquire returned after %v, want to wait about 10ms", waited)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	l.buckets["a"].tokens = -100
+	if err := l.acquire(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire with expired ctx = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestRateUsage(t *testing.T) {
+	b := Bot("ratelimit-test").WebhookURL("http://127.0.0.1/send?key=test")
+	if u := b.RateUsage(); u != (RateUsage{}) {
+		t.Errorf("usage without limiter = %+v, want zero", u)
+	}
+
+	b.RateLimit(4, time.Minute, false)
+	for i := 0; i < 2; i++ {
+		if err := b.limiter.acquire(context.Background(), b.webhookURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u := b.RateUsage()
+	if u.Limit != 4 || u.Period != time.Minute || u.Available != 2 || u.Used != 2 {
+		t.Errorf("usage = %+v", u)
+	}
+	if u.ResetIn < 29*time.Second || u.ResetIn > 30*time.Second {
+		t.Errorf("ResetIn = %v, want about 30s", u.ResetIn)
+	}
+}
@@ -0,0 +1,120 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy 发送失败时的重试策略
+//
+// 仅对网络错误、HTTP 5xx以及企业微信限流错误码进行重试，key错误、参数错误等永久性错误不会重试
+type RetryPolicy struct {
+	MaxAttempts int           // 最大尝试次数(包含首次发送) 小于等于1时不重试
+	Backoff     time.Duration // 首次重试前的等待时间 之后每次翻倍
+	MaxBackoff  time.Duration // 等待时间上限 为0时不限制
+	Jitter      float64       // 等待时间的随机抖动比例 取值0~1 如0.2表示在±20%范围内浮动
+	// OnRetry 每次重试前的回调 attempt为即将进行的第几次尝试 err为上一次的错误 wait为本次等待时间
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// DefaultRetryPolicy 默认的重试策略 最多尝试3次 等待时间从500ms开始翻倍
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     500 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+	Jitter:      0.2,
+}
+
+// Retry 设置机器人发送消息及上传文件的重试策略
+func (r *bot) Retry(policy RetryPolicy) *bot {
+	r.retryPolicy = &policy
+	return r
+}
+
+// backoff 第n次重试(从1开始)前的等待时间
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < n; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// isRetryable 判断错误是否为可重试的临时错误
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.ErrCode == ErrCodeRateLimitExceeded
+	}
+
+	// http.Client返回的错误都包装为*url.Error 需按其中的底层错误判断
+	// 不支持的协议、证书错误、参数校验、客户端限流等永久性错误不重试
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// 建立连接或读写过程中的其他网络错误 如网络不可达
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// withRetry 按机器人的重试策略执行fn op用于日志中标识操作
+func (r *bot) withRetry(ctx context.Context, op string, fn func() error) error {
+	policy := r.retryPolicy
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return fn()
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !isRetryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		wait := policy.backoff(attempt)
+		wxRobot.logger.Warn(fmt.Sprintf("机器人[%s]%s失败，%v后进行第%d次尝试, err: %v", r.name, op, wait, attempt+1, err))
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt+1, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("send: %w", context.DeadlineExceeded), false},
+		{"5xx", &APIError{StatusCode: http.StatusBadGateway}, true},
+		{"4xx", &APIError{StatusCode: http.StatusNotFound}, false},
+		{"rate limit", &APIError{StatusCode: http.StatusOK, ErrCode: ErrCodeRateLimitExceeded}, true},
+		{"invalid key", &APIError{StatusCode: http.StatusOK, ErrCode: ErrCodeInvalidWebhookKey}, false},
+		{"unexpected eof", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"validation", invalidf("content不能为空"), false},
+		{"client rate limit", fmt.Errorf("%w: 客户端限流", ErrRateLimitExceeded), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsRetryableHTTPErrors(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"unsupported scheme", "ftp://example.com/", false},
+		{"untrusted certificate", tlsServer.URL, false},
+		{"connection refused", closedURL, true},
+	}
+	for _, tt := range tests {
+		_, err := http.Get(tt.url)
+		if err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+		if got := isRetryable(err); got != tt.want {
+			t.Errorf("%s: isRetryable(%v) = %v, want %v", tt.name, err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Fatalf("backoff with jitter = %v, want within ±20%% of 100ms", got)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []string // 依次返回的响应 数字开头表示HTTP状态码
+		wantCalls int32
+		wantErr   error
+	}{
+		{"success", []string{`{"errcode":0}`}, 1, nil},
+		{"5xx then success", []string{"502", "503", `{"errcode":0}`}, 3, nil},
+		{"rate limited then success", []string{`{"errcode":45009,"errmsg":"api freq out of limit"}`, `{"errcode":0}`}, 2, nil},
+		{"permanent", []string{`{"errcode":93000,"errmsg":"invalid webhook url"}`}, 1, ErrInvalidWebhookKey},
+		{"gives up", []string{"500", "500", "500", "500"}, 3, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				resp := tt.responses[int(n)-1]
+				var status int
+				if _, err := fmt.Sscanf(resp, "%d", &status); err == nil {
+					w.WriteHeader(status)
+					return
+				}
+				_, _ = w.Write([]byte(resp))
+			}))
+			defer ts.Close()
+
+			var retries int
+			b := Bot(fmt.Sprintf("retry-test-%d", i)).WebhookURL(ts.URL + "/send?key=test").Retry(RetryPolicy{
+				MaxAttempts: 3,
+				Backoff:     time.Millisecond,
+				OnRetry:     func(int, error, time.Duration) { retries++ },
+			})
+			err := b.ToTextMsg("hello").Send()
+
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if retries != int(tt.wantCalls)-1 {
+				t.Errorf("OnRetry called %d times, want %d", retries, tt.wantCalls-1)
+			}
+			switch {
+			case tt.name == "gives up":
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+					t.Errorf("err = %v, want http 500", err)
+				}
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("err = %v, want nil", err)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package wxrobot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MissedRunPolicy 错过执行时间的处理策略
+type MissedRunPolicy int
+
+const (
+	// MissedSkip 进程停止期间错过的执行不补发 运行期间因休眠等原因延迟的多次执行只执行一次
+	MissedSkip MissedRunPolicy = iota
+	// MissedRunOnce 错过的执行只补发一次
+	MissedRunOnce
+	// MissedRunAll 错过的每次执行都补发 最多补发MaxMissedRuns次
+	MissedRunAll
+)
+
+// MaxMissedRuns MissedRunAll策略下最多补发的次数
+const MaxMissedRuns = 100
+
+// ScheduleConfig 定时任务配置
+type ScheduleConfig struct {
+	Missed MissedRunPolicy // 错过执行时间的处理策略 默认为MissedSkip
+	// StateFile 记录每个任务最近一次执行时间的文件 进程重启后据此判断停止期间错过的执行
+	// 为空时只处理运行期间错过的执行；任务以表达式区分，表达式相同的任务按注册顺序区分
+	StateFile string
+}
+
+// ScheduledJob 定时任务
+type ScheduledJob struct {
+	ID      string    // 任务id 用于Unschedule
+	Spec    string    // 定时表达式
+	Next    time.Time // 下一次执行时间
+	LastRun time.Time // 最近一次执行的计划时间 未执行过时为零值
+}
+
+// scheduler 机器人的定时任务
+type scheduler struct {
+	mu       sync.Mutex
+	cfg      ScheduleConfig
+	jobs     map[string]*scheduledJob
+	lastRuns map[string]time.Time // 从StateFile加载及执行后记录的执行时间
+}
+
+type scheduledJob struct {
+	id       string
+	spec     string
+	schedule cronSchedule
+	fn       func() Sender
+	stop     chan struct{}
+	next     time.Time
+	lastRun  time.Time
+}
+
+// Scheduler 设置定时任务的配置 需在Schedule(...)之前调用
+func (r *bot) Scheduler(cfg ScheduleConfig) *bot {
+	s := &r.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cfg = cfg
+	s.lastRuns = make(map[string]time.Time)
+	if cfg.StateFile == "" {
+		return r
+	}
+	bts, err := ioutil.ReadFile(cfg.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			wxRobot.logger.Error("读取定时任务状态失败:", err)
+		}
+		return r
+	}
+	if err = json.Unmarshal(bts, &s.lastRuns); err != nil {
+		wxRobot.logger.Error("解析定时任务状态失败:", err)
+	}
+	return r
+}
+
+// Schedule 按定时表达式定时发送fn返回的消息 fn返回nil时本次不发送
+// spec支持标准的5段表达式(分钟 小时 日 月 星期)、@daily等预定义表达式及@every 1h形式的固定间隔，
+// 可以CRON_TZ=Asia/Shanghai开头指定时区；如工作日9点半: "CRON_TZ=Asia/Shanghai 30 9 * * 1-5"
+// 返回的id可用于Unschedule(id)
+func (r *bot) Schedule(spec string, fn func() Sender) (string, error) {
+	schedule, err := parseCron(spec)
+	if err != nil {
+		return "", err
+	}
+	if fn == nil {
+		return "", fmt.Errorf("wxrobot: 定时任务[%s]的消息函数不能为空", spec)
+	}
+
+	s := &r.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = make(map[string]*scheduledJob)
+	}
+	if s.lastRuns == nil {
+		s.lastRuns = make(map[string]time.Time)
+	}
+	j := &scheduledJob{id: s.newID(spec), spec: spec, schedule: schedule, fn: fn, stop: make(chan struct{})}
+
+	now := time.Now()
+	j.next = schedule.Next(now)
+	if last, ok := s.lastRuns[j.id]; ok {
+		j.lastRun = last
+		// 停止期间错过了执行 立即补发
+		if next := schedule.Next(last); s.cfg.Missed != MissedSkip && !next.IsZero() && next.Before(now) {
+			j.next = next
+		}
+	}
+	if j.next.IsZero() {
+		return "", fmt.Errorf("wxrobot: 定时表达式[%s]没有可执行的时间", spec)
+	}
+
+	s.jobs[j.id] = j
+	go r.runSchedule(j)
+	return j.id, nil
+}
+
+// newID 任务id为定时表达式 表达式相同时依次加上#2、#3
+func (s *scheduler) newID(spec string) string {
+	id := spec
+	for i := 2; s.jobs[id] != nil; i++ {
+		id = spec + "#" + strconv.Itoa(i)
+	}
+	return id
+}
+
+// Unschedule 停止定时任务 正在发送的消息不受影响 任务不存在时返回false
+func (r *bot) Unschedule(id string) bool {
+	s := &r.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	close(j.stop)
+	delete(s.jobs, id)
+	return true
+}
+
+// Schedules 返回所有定时任务 按下一次执行时间排序
+func (r *bot) Schedules() []ScheduledJob {
+	s := &r.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]ScheduledJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, ScheduledJob{ID: j.id, Spec: j.spec, Next: j.next, LastRun: j.lastRun})
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		if !jobs[i].Next.Equal(jobs[k].Next) {
+			return jobs[i].Next.Before(jobs[k].Next)
+		}
+		return jobs[i].ID < jobs[k].ID
+	})
+	return jobs
+}
+
+// runSchedule 等待到执行时间后发送消息 直到任务被停止
+func (r *bot) runSchedule(j *scheduledJob) {
+	s := &r.sched
+	for {
+		s.mu.Lock()
+		next, policy := j.next, s.cfg.Missed
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-j.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// 计算到当前为止应执行的次数
+		now := time.Now()
+		last, due := next, 1
+		for t := j.schedule.Next(next); !t.IsZero() && !t.After(now) && due < MaxMissedRuns; t = j.schedule.Next(t) {
+			last = t
+			due++
+		}
+		runs := 1
+		if policy == MissedRunAll {
+			runs = due
+		}
+		if due > 1 {
+			wxRobot.logger.Warn(fmt.Sprintf("机器人[%s]定时任务[%s]错过了%d次执行, 本次执行%d次", r.name, j.id, due-1, runs))
+		}
+
+		for i := 0; i < runs; i++ {
+			select {
+			case <-j.stop:
+				return
+			default:
+			}
+			r.runScheduledJob(j)
+		}
+
+		s.mu.Lock()
+		j.lastRun = last
+		j.next = j.schedule.Next(last)
+		if !j.next.After(now) {
+			j.next = j.schedule.Next(now)
+		}
+		s.lastRuns[j.id] = last
+		s.saveState()
+		next = j.next
+		s.mu.Unlock()
+
+		if next.IsZero() {
+			wxRobot.logger.Warn(fmt.Sprintf("机器人[%s]定时任务[%s]没有下一次执行时间, 已停止", r.name, j.id))
+			r.Unschedule(j.id)
+			return
+		}
+	}
+}
+
+// runScheduledJob 执行一次定时任务 fn的panic不会影响其他任务
+func (r *bot) runScheduledJob(j *scheduledJob) {
+	defer func() {
+		if err := recover(); err != nil {
+			wxRobot.logger.Error(fmt.Sprintf("机器人[%s]定时任务[%s]执行失败: %v", r.name, j.id, err))
+		}
+	}()
+
+	msg := j.fn()
+	if msg == nil {
+		return
+	}
+	if err := msg.SendContext(context.Background()); err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("机器人[%s]定时任务[%s]发送消息失败: %v", r.name, j.id, err))
+	}
+}
+
+// saveState 记录每个任务最近一次执行时间 需持有s.mu
+func (s *scheduler) saveState() {
+	if s.cfg.StateFile == "" {
+		return
+	}
+
+	bts, err := json.Marshal(s.lastRuns)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(s.cfg.StateFile), "."+filepath.Base(s.cfg.StateFile)+".tmp")
+		if err = ioutil.WriteFile(tmp, bts, 0644); err == nil {
+			err = os.Rename(tmp, s.cfg.StateFile)
+		}
+	}
+	if err != nil {
+		wxRobot.logger.Error("保存定时任务状态失败:", err)
+	}
+}
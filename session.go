@@ -0,0 +1,307 @@
+package wxrobot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultSessionTTL 会话默认有效期 超过有效期未更新的会话会被清除
+const DefaultSessionTTL = 30 * time.Minute
+
+// SessionData 会话中保存的数据
+type SessionData struct {
+	State    string                     `json:"state"`     // 状态机的当前状态
+	Values   map[string]json.RawMessage `json:"values"`    // Session().Set(...)保存的值
+	ExpireAt time.Time                  `json:"expire_at"` // 过期时间
+}
+
+// SessionStore 保存会话数据
+type SessionStore interface {
+	// Load 读取会话 不存在或已过期时返回nil
+	Load(key string) (*SessionData, error)
+	// Save 保存会话
+	Save(key string, data *SessionData) error
+	// Delete 删除会话 会话不存在时不返回错误
+	Delete(key string) error
+}
+
+// memorySessionStore 内存中保存会话 进程重启后丢失
+type memorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*SessionData
+	lastSweep time.Time
+}
+
+// NewMemorySessionStore 内存存储 未设置Sessions(...)时默认使用
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: make(map[string]*SessionData)}
+}
+
+func (s *memorySessionStore) Load(key string) (*SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// 定期清理过期的会话
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, data := range s.sessions {
+			if now.After(data.ExpireAt) {
+				delete(s.sessions, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	data, ok := s.sessions[key]
+	if !ok || now.After(data.ExpireAt) {
+		return nil, nil
+	}
+	return data.clone(), nil
+}
+
+func (s *memorySessionStore) Save(key string, data *SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[key] = data.clone()
+	return nil
+}
+
+func (s *memorySessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, key)
+	return nil
+}
+
+// fileSessionStore 每个会话保存为目录下的一个文件
+type fileSessionStore struct {
+	dir string
+}
+
+// NewFileSessionStore 文件存储 每个会话保存为dir下的一个文件 目录不存在时自动创建
+func NewFileSessionStore(dir string) (SessionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileSessionStore{dir: dir}, nil
+}
+
+// path 会话key中含有chatid等字符 使用hash作为文件名
+func (s *fileSessionStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".session")
+}
+
+func (s *fileSessionStore) Load(key string) (*SessionData, error) {
+	bt, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := new(SessionData)
+	if err = json.Unmarshal(bt, data); err != nil {
+		return nil, err
+	}
+	if time.Now().After(data.ExpireAt) {
+		_ = s.Delete(key)
+		return nil, nil
+	}
+	return data, nil
+}
+
+func (s *fileSessionStore) Save(key string, data *SessionData) error {
+	bt, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err = ioutil.WriteFile(path+".tmp", bt, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *fileSessionStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *SessionData) clone() *SessionData {
+	c := &SessionData{State: d.State, ExpireAt: d.ExpireAt, Values: make(map[string]json.RawMessage, len(d.Values))}
+	for k, v := range d.Values {
+		c.Values[k] = v
+	}
+	return c
+}
+
+// sessions 机器人的会话配置
+type sessions struct {
+	store SessionStore
+	ttl   time.Duration
+}
+
+// defaultSessions 未调用Sessions(...)时使用 会话key中包含机器人名字 多个机器人可共用
+var defaultSessions = &sessions{store: NewMemorySessionStore(), ttl: DefaultSessionTTL}
+
+// Sessions 设置会话的存储及有效期 ttl小于等于0时为DefaultSessionTTL
+// 多个实例共享会话时需使用共享的存储
+func (r *bot) Sessions(store SessionStore, ttl time.Duration) *bot {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	r.sessions = &sessions{store: store, ttl: ttl}
+	return r
+}
+
+func (r *bot) sessionConfig() *sessions {
+	if r.sessions != nil {
+		return r.sessions
+	}
+	return defaultSessions
+}
+
+// Session 用户在会话中的状态 按(ChatId, From.UserId)区分
+type Session struct {
+	cfg  *sessions
+	key  string
+	data *SessionData
+	err  error
+}
+
+// Session 返回发送当前消息的用户在当前会话中的Session 同一条消息多次调用返回同一个Session
+func (r *FromCommonMsg) Session() *Session {
+	if r.session != nil {
+		return r.session
+	}
+
+	cfg := r.bot.sessionConfig()
+	s := &Session{cfg: cfg, key: r.bot.name + "|" + r.ChatId + "|" + r.From.UserId}
+	s.data, s.err = cfg.store.Load(s.key)
+	if s.err != nil {
+		wxRobot.logger.Error(fmt.Sprintf("读取会话[%s]失败: %v", s.key, s.err))
+	}
+	if s.data == nil {
+		s.data = &SessionData{}
+	}
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]json.RawMessage)
+	}
+	r.session = s
+	return s
+}
+
+// Err 读取会话时的错误 出错时Session为空会话
+func (s *Session) Err() error {
+	return s.err
+}
+
+// State 状态机的当前状态 新会话为空字符串
+func (s *Session) State() string {
+	return s.data.State
+}
+
+// SetState 设置状态机的状态 并刷新会话有效期
+func (s *Session) SetState(state string) error {
+	s.data.State = state
+	return s.save()
+}
+
+// Get 读取名为name的值到v中 值不存在时返回false
+func (s *Session) Get(name string, v interface{}) (bool, error) {
+	raw, ok := s.data.Values[name]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set 保存值 v需能编码为JSON 并刷新会话有效期
+func (s *Session) Set(name string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.data.Values[name] = raw
+	return s.save()
+}
+
+// Delete 删除名为name的值
+func (s *Session) Delete(name string) error {
+	delete(s.data.Values, name)
+	return s.save()
+}
+
+// Clear 清空会话 状态回到初始状态
+func (s *Session) Clear() error {
+	s.data = &SessionData{Values: make(map[string]json.RawMessage)}
+	return s.cfg.store.Delete(s.key)
+}
+
+func (s *Session) save() error {
+	s.data.ExpireAt = time.Now().Add(s.cfg.ttl)
+	return s.cfg.store.Save(s.key, s.data)
+}
+
+// FSM 按用户的会话状态分发文本消息 用于实现多步骤的对话
+//
+// 初始状态的处理函数中调用msg.Session().SetState("priority")进入下一步 例:
+//
+//	fsm := wxrobot.NewFSM().
+//		On("", startHandler).
+//		On("priority", priorityHandler).
+//		On("confirm", confirmHandler)
+//	bot.RegisterHandlerForText(fsm.Handle)
+type FSM struct {
+	mu       sync.RWMutex
+	handlers map[string]textHandler
+}
+
+// NewFSM 新建状态机
+func NewFSM() *FSM {
+	return &FSM{handlers: make(map[string]textHandler)}
+}
+
+// On 注册状态state下的文本消息处理函数 state为空字符串时为初始状态
+func (f *FSM) On(state string, handler textHandler) *FSM {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[state] = handler
+	return f
+}
+
+// Handle 将文本消息交给用户当前状态的处理函数
+// 当前状态没有注册处理函数时(如会话已过期后状态被修改) 清空会话并交给初始状态的处理函数
+func (f *FSM) Handle(msg *FromTextMsg) {
+	session := msg.Session()
+	state := session.State()
+
+	f.mu.RLock()
+	handler, ok := f.handlers[state]
+	if !ok {
+		handler = f.handlers[""]
+	}
+	f.mu.RUnlock()
+
+	if !ok {
+		wxRobot.logger.Warn(fmt.Sprintf("状态[%s]没有注册处理函数, 回到初始状态", state))
+		if err := session.Clear(); err != nil {
+			wxRobot.logger.Error("清空会话失败:", err)
+		}
+	}
+	if handler != nil {
+		handler(msg)
+	}
+}
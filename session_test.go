@@ -0,0 +1,138 @@
+package wxrobot
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStoreTTL(t *testing.T) {
+	fileStore, err := NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]SessionStore{
+		"memory": NewMemorySessionStore(),
+		"file":   fileStore,
+	}
+	for name, s := range stores {
+		data := &SessionData{
+			State:    "priority",
+			Values:   map[string]json.RawMessage{"title": json.RawMessage(`"磁盘告警"`)},
+			ExpireAt: time.Now().Add(30 * time.Millisecond),
+		}
+		if err = s.Save("bot|chat|user", data); err != nil {
+			t.Fatalf("%s: Save: %v", name, err)
+		}
+		// 修改保存后的数据不影响存储中的会话
+		data.State = "changed"
+
+		got, err := s.Load("bot|chat|user")
+		if err != nil || got == nil || got.State != "priority" || string(got.Values["title"]) != `"磁盘告警"` {
+			t.Fatalf("%s: Load = %+v, %v", name, got, err)
+		}
+		if got, _ = s.Load("bot|chat|other"); got != nil {
+			t.Errorf("%s: Load of unknown key = %+v, want nil", name, got)
+		}
+
+		// 过期后返回nil
+		time.Sleep(50 * time.Millisecond)
+		if got, err = s.Load("bot|chat|user"); got != nil || err != nil {
+			t.Errorf("%s: Load after ttl = %+v, %v, want nil", name, got, err)
+		}
+		if err = s.Delete("bot|chat|user"); err != nil {
+			t.Errorf("%s: Delete of expired session: %v", name, err)
+		}
+	}
+}
+
+// newSessionTextMsg 用户userID在会话chatID中发送的文本消息
+func newSessionTextMsg(b *bot, chatID, userID, content string) *FromTextMsg {
+	msg := &FromTextMsg{}
+	msg.bot = b
+	msg.ChatId = chatID
+	msg.From.UserId = userID
+	msg.Text.Content = content
+	return msg
+}
+
+func TestSession(t *testing.T) {
+	b := Bot("session-test").Sessions(NewMemorySessionStore(), 30*time.Millisecond)
+
+	msg := newSessionTextMsg(b, "chat", "zhangsan", "hi")
+	s := msg.Session()
+	if s != msg.Session() || s.State() != "" || s.Err() != nil {
+		t.Fatal("new session should be empty and cached on the message")
+	}
+	if err := s.Set("title", "磁盘告警"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetState("priority"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一用户的下一条消息读取到之前保存的值
+	next := newSessionTextMsg(b, "chat", "zhangsan", "P1").Session()
+	var title string
+	if ok, err := next.Get("title", &title); !ok || err != nil || title != "磁盘告警" || next.State() != "priority" {
+		t.Errorf("next message session: title = %q, state = %q, %v, %v", title, next.State(), ok, err)
+	}
+	// 会话按(ChatId, From.UserId)区分
+	if other := newSessionTextMsg(b, "chat", "lisi", "hi").Session(); other.State() != "" {
+		t.Errorf("another user shares the session")
+	}
+	if other := newSessionTextMsg(b, "other-chat", "zhangsan", "hi").Session(); other.State() != "" {
+		t.Errorf("another chat shares the session")
+	}
+
+	// 过期后为新会话
+	time.Sleep(50 * time.Millisecond)
+	if expired := newSessionTextMsg(b, "chat", "zhangsan", "hi").Session(); expired.State() != "" {
+		t.Errorf("session state after ttl = %q, want empty", expired.State())
+	}
+}
+
+func TestFSM(t *testing.T) {
+	b := Bot("session-test-fsm").Sessions(NewMemorySessionStore(), time.Hour)
+
+	var steps []string
+	fsm := NewFSM().
+		On("", func(msg *FromTextMsg) {
+			steps = append(steps, "start:"+msg.Text.Content)
+			_ = msg.Session().Set("title", msg.Text.Content)
+			_ = msg.Session().SetState("priority")
+		}).
+		On("priority", func(msg *FromTextMsg) {
+			var title string
+			_, _ = msg.Session().Get("title", &title)
+			steps = append(steps, "priority:"+title+"/"+msg.Text.Content)
+			_ = msg.Session().Clear()
+		})
+
+	send := func(content string) {
+		fsm.Handle(newSessionTextMsg(b, "chat", "zhangsan", content))
+	}
+	send("磁盘告警")
+	send("P1")
+	send("网络告警")
+
+	// 状态没有注册处理函数时 清空会话并回到初始状态
+	stale := newSessionTextMsg(b, "chat", "zhangsan", "")
+	if err := stale.Session().SetState("removed"); err != nil {
+		t.Fatal(err)
+	}
+	send("回到初始状态")
+	if state := newSessionTextMsg(b, "chat", "zhangsan", "").Session().State(); state != "priority" {
+		t.Errorf("state after fallback = %q, want priority", state)
+	}
+	var title string
+	if ok, _ := newSessionTextMsg(b, "chat", "zhangsan", "").Session().Get("title", &title); !ok || title != "回到初始状态" {
+		t.Errorf("title after fallback = %q, want the value saved by the initial handler", title)
+	}
+
+	want := "start:磁盘告警,priority:磁盘告警/P1,start:网络告警,start:回到初始状态"
+	if got := strings.Join(steps, ","); got != want {
+		t.Errorf("steps = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package wxrobot
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// 企业微信对消息内容的长度限制(字节)
+const (
+	MaxTextBytes     = 2048
+	MaxMarkdownBytes = 4096
+)
+
+// splitNumberReserve 为分段编号(如"(1/3) ")预留的字节数
+const splitNumberReserve = 16
+
+// splitContent 将超长的内容拆分为多段 每段不超过limit字节
+// 拆分点总是落在UTF-8字符边界上，优先选择段落和换行处；markdown内容不会拆断标签、链接、加粗及行内代码，
+// 超过limit的<font>或加粗内容会在拆分处闭合并在下一段重新打开，超过limit的标签、链接及行内代码无法拆分时返回错误
+func splitContent(content string, limit int, markdown bool, numbered bool) ([]string, error) {
+	if len(content) <= limit {
+		return []string{content}, nil
+	}
+	if numbered {
+		limit -= splitNumberReserve
+	}
+
+	var parts []string
+	for len(content) > limit {
+		cut, state := splitPoint(content, limit, markdown)
+		if cut == 0 {
+			return nil, fmt.Errorf("%w: markdown的标签、链接或行内代码超过%d字节, 无法拆分", ErrContentTooLong, limit)
+		}
+		part := strings.TrimRight(content[:cut], "\n")
+		if closing := state.closing(); closing != "" {
+			// 闭合标签前的空白会导致加粗无法渲染
+			part = strings.TrimRight(part, " \n") + closing
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+		content = state.reopen() + strings.TrimLeft(content[cut:], "\n")
+	}
+	if content != "" {
+		parts = append(parts, content)
+	}
+
+	if numbered && len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), parts[i])
+		}
+	}
+	return parts, nil
+}
+
+// splitPoint 返回content中不超过limit的最佳拆分位置及拆分处的markdown状态 markdown无法拆分时返回0
+func splitPoint(content string, limit int, markdown bool) (int, markdownCut) {
+	end := limit
+	for end > 0 && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	if !markdown {
+		return plainSplitPoint(content, end), markdownCut{}
+	}
+
+	// 优先在<font>及加粗之外拆分 其次在其内部拆分并在下一段重新打开
+	for _, inSpan := range []bool{false, true} {
+		accept := func(cut int) (markdownCut, bool) {
+			state := markdownAt(content, cut)
+			if state.broken || (len(state.open) > 0) != inSpan {
+				return state, false
+			}
+			// 闭合标签需计入本段长度 重新打开的标签之后需有新的内容
+			return state, cut+len(state.closing()) <= limit && cut > len(state.reopen())
+		}
+
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			window := content[:end]
+			for {
+				i := strings.LastIndex(window, sep)
+				if i <= end/3 {
+					break
+				}
+				if state, ok := accept(i + len(sep)); ok {
+					return i + len(sep), state
+				}
+				window = window[:i]
+			}
+		}
+		for cut := end; cut > 0; cut-- {
+			if !utf8.RuneStart(content[cut]) {
+				continue
+			}
+			if state, ok := accept(cut); ok {
+				return cut, state
+			}
+		}
+	}
+	return 0, markdownCut{}
+}
+
+// plainSplitPoint 返回纯文本不超过end的最佳拆分位置
+func plainSplitPoint(content string, end int) int {
+	// 依次尝试在段落、换行、空格处拆分 拆分点太靠前时放弃 避免产生过短的分段
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(content[:end], sep); i > end/3 {
+			return i + len(sep)
+		}
+	}
+	if end == 0 {
+		// limit小于一个字符时 至少保留一个完整字符
+		_, size := utf8.DecodeRuneInString(content)
+		return size
+	}
+	return end
+}
+
+// markdownCut 在某处拆分时的markdown状态
+type markdownCut struct {
+	broken bool     // 拆分点位于标签、链接、行内代码或**中间
+	open   []string // 拆分点处未闭合的<font ...>及** 按打开的顺序
+}
+
+// closing 闭合未闭合的<font>及加粗
+func (m markdownCut) closing() string {
+	var sb strings.Builder
+	for i := len(m.open) - 1; i >= 0; i-- {
+		if isBold(m.open[i]) {
+			sb.WriteString("**")
+		} else {
+			sb.WriteString("</font>")
+		}
+	}
+	return sb.String()
+}
+
+// reopen 在下一段重新打开<font>及加粗
+func (m markdownCut) reopen() string {
+	return strings.Join(m.open, "")
+}
+
+// markdownSafeCut 判断在content[:cut]之后拆分是否会拆断markdown结构
+func markdownSafeCut(content string, cut int) bool {
+	state := markdownAt(content, cut)
+	return !state.broken && len(state.open) == 0
+}
+
+// markdownAt 返回在content[:cut]之后拆分时的markdown状态 拆分点之后的内容用于识别被拆断的标签及**
+func markdownAt(content string, cut int) markdownCut {
+	var inTag, inLinkText, inLinkURL, inCode bool
+	var open []string
+	for i := 0; i < cut; i++ {
+		c := content[i]
+		switch {
+		case inCode:
+			inCode = c != '`'
+		case inTag:
+			inTag = c != '>'
+		case c == '`':
+			inCode = true
+		case c == '<':
+			// 企业微信markdown仅支持<font>及<@userid> 其他的<为普通字符
+			rest := content[i:]
+			if strings.HasPrefix(rest, "</font") {
+				inTag = true
+				open = removeLast(open, func(s string) bool { return !isBold(s) })
+			} else if strings.HasPrefix(rest, "<font") {
+				inTag = true
+				if j := strings.IndexByte(rest, '>'); j > 0 {
+					open = append(open, rest[:j+1])
+				}
+			} else if strings.HasPrefix(rest, "<@") {
+				inTag = true
+			}
+		case c == '[':
+			inLinkText = true
+		case c == ']' && inLinkText:
+			inLinkText = false
+			inLinkURL = i+1 < len(content) && content[i+1] == '('
+		case c == ')' && inLinkURL:
+			inLinkURL = false
+		case c == '*' && strings.HasPrefix(content[i:], "**"):
+			if i+1 == cut {
+				// 拆分点位于**中间
+				return markdownCut{broken: true}
+			}
+			if bold := removeLast(open, isBold); len(bold) < len(open) {
+				open = bold
+			} else {
+				open = append(open, "**")
+			}
+			i++
+		}
+	}
+	return markdownCut{broken: inTag || inLinkText || inLinkURL || inCode, open: open}
+}
+
+func isBold(s string) bool {
+	return s == "**"
+}
+
+// removeLast 删除最后一个满足match的元素
+func removeLast(items []string, match func(string) bool) []string {
+	for i := len(items) - 1; i >= 0; i-- {
+		if match(items[i]) {
+			return append(items[:i:i], items[i+1:]...)
+		}
+	}
+	return items
+}
@@ -0,0 +1,162 @@
+package wxrobot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		limit    int
+		markdown bool
+		numbered bool
+		want     []string
+	}{
+		{"short", "hello", 10, false, false, []string{"hello"}},
+		{"paragraph", "aaaa\n\nbbbb\n\ncccc", 12, false, false, []string{"aaaa\n\nbbbb", "cccc"}},
+		{"newline", "aaaa\nbbbb\ncccc", 10, false, false, []string{"aaaa\nbbbb", "cccc"}},
+		{"space", "aaaa bbbb cccc", 10, false, false, []string{"aaaa bbbb ", "cccc"}},
+		{"hard", "abcdefghij", 4, false, false, []string{"abcd", "efgh", "ij"}},
+		{"utf8", "你好世界", 7, false, false, []string{"你好", "世界"}},
+		{"link", "see [docs](http://x/y) now", 20, true, false, []string{"see ", "[docs](http://x/y) ", "now"}},
+		{"font", `a <font color="info">ok</font> b`, 30, true, false, []string{`a <font color="info">ok</font>`, " b"}},
+		{"bold", "xx **bold text** yy", 15, true, false, []string{"xx ", "**bold text** ", "yy"}},
+		{"code", "run `make all` now", 10, true, false, []string{"run ", "`make all`", " now"}},
+		{"numbered", strings.Repeat("a ", 20), 30, false, true, []string{"(1/3) a a a a a a a ", "(2/3) a a a a a a a ", "(3/3) a a a a a a "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitContent(tt.content, tt.limit, tt.markdown, tt.numbered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("splitContent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitContentLimits(t *testing.T) {
+	content := strings.Repeat("企业微信机器人 **加粗** [链接](http://example.com) `code`\n", 300)
+	for _, markdown := range []bool{false, true} {
+		parts, err := splitContent(content, MaxMarkdownBytes, markdown, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) < 2 {
+			t.Fatalf("expected several parts, got %d", len(parts))
+		}
+		for i, part := range parts {
+			if len(part) > MaxMarkdownBytes {
+				t.Errorf("part %d is %d bytes", i, len(part))
+			}
+			if !utf8.ValidString(part) {
+				t.Errorf("part %d is not valid utf8", i)
+			}
+		}
+	}
+}
+
+func TestSplitContentBareLessThan(t *testing.T) {
+	content := "a < b\n" + strings.Repeat("x", 6000)
+	parts, err := splitContent(content, MaxMarkdownBytes, true, false)
+	if err != nil || len(parts) != 2 || len(parts[0]) != MaxMarkdownBytes {
+		t.Errorf("got %d parts, err %v", len(parts), err)
+	}
+}
+
+func TestSplitContentLongSpans(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		want    []string
+	}{
+		{"font", `<font color="warning">aaaa bbbb cccc</font>`, 40,
+			[]string{`<font color="warning">aaaa bbbb</font>`, `<font color="warning">cccc</font>`}},
+		{"font after text", "hi\n<font color=\"info\">aaaaaaaaaaaaaaaa</font>", 30,
+			[]string{"hi", `<font color="info">aaaa</font>`, `<font color="info">aaaa</font>`, `<font color="info">aaaa</font>`, `<font color="info">aaaa</font>`}},
+		{"bold", "**aaaa bbbb cccc dddd**", 14, []string{"**aaaa bbbb**", "**cccc dddd**"}},
+		{"bold in font", `<font color="info">**aaaa bbbb**</font>`, 38,
+			[]string{`<font color="info">**aaaa**</font>`, `<font color="info">**bbbb**</font>`}},
+	}
+	for _, tt := range tests {
+		got, err := splitContent(tt.content, tt.limit, true, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: splitContent = %q, want %q", tt.name, got, tt.want)
+		}
+		for i, part := range got {
+			if len(part) > tt.limit || !markdownSafeCut(part, len(part)) {
+				t.Errorf("%s: part %d %q is broken or longer than %d", tt.name, i, part, tt.limit)
+			}
+		}
+	}
+
+	// 6000字节的警告信息 每段都是完整的<font>
+	warning := `<font color="warning">` + strings.Repeat("磁盘空间不足 ", 400) + `</font>`
+	parts, err := splitContent(warning, MaxMarkdownBytes, true, true)
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("got %d parts, err %v", len(parts), err)
+	}
+	for i, part := range parts {
+		if len(part) > MaxMarkdownBytes || !strings.Contains(part, `<font color="warning">`) || !strings.HasSuffix(part, "</font>") {
+			t.Errorf("part %d is %d bytes: %.40q...%q", i, len(part), part, part[len(part)-10:])
+		}
+	}
+
+	// 链接、行内代码及标签无法拆分
+	for _, content := range []string{
+		"see [docs](http://example.com/" + strings.Repeat("x", 5000) + ")",
+		"run `" + strings.Repeat("x", 5000) + "`",
+		`<font color="` + strings.Repeat("x", 5000) + `">a</font>`,
+	} {
+		if _, err := splitContent(content, MaxMarkdownBytes, true, false); !errors.Is(err, ErrContentTooLong) {
+			t.Errorf("%.20q: err = %v, want ErrContentTooLong", content, err)
+		}
+	}
+}
+
+func TestMarkdownSafeCut(t *testing.T) {
+	// |为拆分点
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"plain| text", true},
+		{"1 < 2| and 3 > 2", true},
+		{"<font color|", false},
+		{`<font color="info">open|</font>`, false},
+		{`<font color="info">ok</font>|`, true},
+		{"abc <fo|nt>", false},
+		{`<font color="info">x</fo|nt>`, false},
+		{"hi <@zhang|>", false},
+		{"hi <@zhang>|", true},
+		{"[docs|](http://x)", false},
+		{"[docs]|(http://x)", false},
+		{"[docs](http://x|)", false},
+		{"[docs](http://x)|", true},
+		{"[note]| text", true},
+		{"**bold| text**", false},
+		{"*|*bold**", false},
+		{"**bold**|", true},
+		{"5*| 3", true},
+		{"`code| more`", false},
+		{"`a <font` b|", true},
+	}
+	for _, tt := range tests {
+		cut := strings.Index(tt.content, "|")
+		content := strings.Replace(tt.content, "|", "", 1)
+		if got := markdownSafeCut(content, cut); got != tt.want {
+			t.Errorf("markdownSafeCut(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
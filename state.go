@@ -0,0 +1,126 @@
+package wxrobot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stateMarker 签名状态的前缀 CallbackID或Value中含有该前缀时点击事件需通过校验
+const stateMarker = "wxs1."
+
+// stateMacBytes 签名截取的字节数 按钮的value最长128字节 需为状态留出空间
+const stateMacBytes = 16
+
+var (
+	ErrStateMissing = errors.New("wxrobot: signed state missing")
+	ErrStateInvalid = errors.New("wxrobot: signed state invalid")
+	ErrStateExpired = errors.New("wxrobot: signed state expired")
+)
+
+// ButtonSecret 设置按钮状态签名的密钥 多个实例需使用相同的密钥
+func (r *bot) ButtonSecret(secret string) *bot {
+	r.stateSecret = []byte(secret)
+	return r
+}
+
+// SignState 将state编码为JSON并签名 结果可作为MsgAction.Value或CallbackID(的一部分)
+// ttl后点击无效 ttl小于等于0时不过期；按钮的value最长128字节，state应尽量精简
+// 如: NewButton("approve", "批准").Value(token)或ToMarkdownMsg(...).Buttons("deploy-"+token, ...)
+func (r *bot) SignState(state interface{}, ttl time.Duration) (string, error) {
+	if len(r.stateSecret) == 0 {
+		return "", errors.New("wxrobot: 请先调用ButtonSecret(...)设置签名密钥")
+	}
+	bts, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl).Unix()
+	}
+	content := stateMarker + base64.RawURLEncoding.EncodeToString(bts) + "." + strconv.FormatInt(expireAt, 36)
+	return content + "." + r.stateMac(content), nil
+}
+
+func (r *bot) stateMac(content string) string {
+	mac := hmac.New(sha256.New, r.stateSecret)
+	mac.Write([]byte(content))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:stateMacBytes])
+}
+
+// verifyState 校验s中的签名状态 返回JSON编码的状态 s中不含签名状态时返回ErrStateMissing
+func (r *bot) verifyState(s string) ([]byte, error) {
+	i := strings.Index(s, stateMarker)
+	if i < 0 {
+		return nil, ErrStateMissing
+	}
+	if len(r.stateSecret) == 0 {
+		return nil, fmt.Errorf("%w: 未设置ButtonSecret(...)", ErrStateInvalid)
+	}
+
+	parts := strings.Split(s[i+len(stateMarker):], ".")
+	if len(parts) != 3 {
+		return nil, ErrStateInvalid
+	}
+	content := stateMarker + parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(r.stateMac(content))) {
+		return nil, ErrStateInvalid
+	}
+
+	expireAt, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return nil, ErrStateInvalid
+	}
+	if expireAt > 0 && time.Now().Unix() > expireAt {
+		return nil, ErrStateExpired
+	}
+
+	bts, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrStateInvalid
+	}
+	return bts, nil
+}
+
+// verifyAttachment 校验点击事件中所有的签名状态 伪造或过期的点击不会交给处理函数
+func (r *bot) verifyAttachment(msg *FromAttachmentMsg) error {
+	if strings.Contains(msg.Attachment.CallbackID, stateMarker) {
+		if _, err := r.verifyState(msg.Attachment.CallbackID); err != nil {
+			return fmt.Errorf("callback_id: %w", err)
+		}
+	}
+	for _, action := range msg.Attachment.Actions {
+		if strings.Contains(action.Value, stateMarker) {
+			if _, err := r.verifyState(action.Value); err != nil {
+				return fmt.Errorf("按钮[%s]: %w", action.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CallbackState 校验并解码CallbackID中由bot.SignState(...)签名的状态
+func (r *FromAttachmentMsg) CallbackState(v interface{}) error {
+	bts, err := r.bot.verifyState(r.Attachment.CallbackID)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, v)
+}
+
+// ActionState 校验并解码按钮Value中由bot.SignState(...)签名的状态
+func (r *FromAttachmentMsg) ActionState(action MsgAction, v interface{}) error {
+	bts, err := r.bot.verifyState(action.Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, v)
+}
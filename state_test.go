@@ -0,0 +1,103 @@
+package wxrobot
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDeploy struct {
+	ID  int    `json:"id"`
+	Env string `json:"env"`
+}
+
+func TestSignState(t *testing.T) {
+	b := Bot("state-test").ButtonSecret("secret")
+	token, err := b.SignState(testDeploy{ID: 42, Env: "prod"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, stateMarker) || len(token) > MaxButtonValueBytes {
+		t.Errorf("token %q should start with %q and fit in a button value", token, stateMarker)
+	}
+
+	// 过期时间已过的签名
+	content := stateMarker + strings.SplitN(strings.TrimPrefix(token, stateMarker), ".", 2)[0] +
+		"." + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 36)
+	expired := content + "." + b.stateMac(content)
+
+	other := Bot("state-test-other").ButtonSecret("another secret")
+	noSecret := Bot("state-test-nosecret")
+	tampered := []byte(token)
+	tampered[len(stateMarker)+2] ^= 1
+
+	tests := []struct {
+		name    string
+		bot     *bot
+		s       string
+		wantErr error
+	}{
+		{"valid", b, token, nil},
+		{"embedded in callback id", b, "deploy-" + token, nil},
+		{"missing", b, "deploy-42", ErrStateMissing},
+		{"tampered payload", b, string(tampered), ErrStateInvalid},
+		{"truncated", b, token[:len(token)-3], ErrStateInvalid},
+		{"extra part", b, token + ".x", ErrStateInvalid},
+		{"expired", b, expired, ErrStateExpired},
+		{"other secret", other, token, ErrStateInvalid},
+		{"no secret", noSecret, token, ErrStateInvalid},
+	}
+	for _, tt := range tests {
+		bts, err := tt.bot.verifyState(tt.s)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr == nil && string(bts) != `{"id":42,"env":"prod"}` {
+			t.Errorf("%s: state = %s", tt.name, bts)
+		}
+	}
+
+	if _, err := noSecret.SignState(1, 0); err == nil {
+		t.Error("SignState without secret should fail")
+	}
+}
+
+func TestAttachmentState(t *testing.T) {
+	b := Bot("state-test-attachment").ButtonSecret("secret")
+	token, err := b.SignState(testDeploy{ID: 7}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &FromAttachmentMsg{}
+	msg.bot = b
+	msg.Attachment.CallbackID = "deploy-" + token
+	msg.Attachment.Actions = []MsgAction{{Name: "approve", Value: token}, {Name: "plain", Value: "yes"}}
+	if err = b.verifyAttachment(msg); err != nil {
+		t.Fatalf("verifyAttachment: %v", err)
+	}
+
+	var fromCallback, fromAction testDeploy
+	if err = msg.CallbackState(&fromCallback); err != nil || fromCallback.ID != 7 {
+		t.Errorf("CallbackState = %+v, %v", fromCallback, err)
+	}
+	if err = msg.ActionState(msg.Attachment.Actions[0], &fromAction); err != nil || fromAction.ID != 7 {
+		t.Errorf("ActionState = %+v, %v", fromAction, err)
+	}
+	if err = msg.ActionState(msg.Attachment.Actions[1], &fromAction); !errors.Is(err, ErrStateMissing) {
+		t.Errorf("ActionState without token: err = %v, want ErrStateMissing", err)
+	}
+
+	// 任一签名无效时整个点击事件都会被丢弃
+	forged := "A"
+	if strings.HasSuffix(token, forged) {
+		forged = "B"
+	}
+	msg.Attachment.Actions[0].Value = token[:len(token)-1] + forged
+	if err = b.verifyAttachment(msg); !errors.Is(err, ErrStateInvalid) {
+		t.Errorf("verifyAttachment with forged action: err = %v, want ErrStateInvalid", err)
+	}
+}
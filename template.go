@@ -0,0 +1,265 @@
+package wxrobot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// TemplateKind 模版渲染出的消息类型
+type TemplateKind string
+
+const (
+	TemplateText     TemplateKind = "text"     // 文本消息
+	TemplateMarkdown TemplateKind = "markdown" // markdown消息
+	TemplateNews     TemplateKind = "news"     // 图文消息 模版需渲染为NewsArticle的JSON数组
+)
+
+// templateExts 从目录加载模版时 文件扩展名对应的消息类型
+var templateExts = map[string]TemplateKind{
+	".txt":  TemplateText,
+	".md":   TemplateMarkdown,
+	".json": TemplateNews,
+}
+
+var ErrTemplateNotFound = errors.New("wxrobot: template not found")
+
+// templateFuncs 模版中可用的辅助函数
+var templateFuncs = template.FuncMap{
+	// escape 转义标签语法及@提及 {{escape .Name}}
+	"escape": EscapeMarkdown,
+	// info/comment/warning 带颜色的文本 内容会被转义 {{warning .Status}}
+	"info":    func(s string) string { return NewMarkdown().Info(s).String() },
+	"comment": func(s string) string { return NewMarkdown().Comment(s).String() },
+	"warning": func(s string) string { return NewMarkdown().Warning(s).String() },
+	// mention @群成员 {{mention .UserId}}
+	"mention": func(userid string) string { return NewMarkdown().Mention(userid).String() },
+	// truncate 按字数截断 超出时以...结尾 {{.Title | truncate 20}}
+	"truncate": truncateRunes,
+	// formatTime 格式化时间 {{.CreatedAt | formatTime "2006-01-02 15:04"}}
+	"formatTime": func(layout string, t time.Time) string { return t.Format(layout) },
+	// now 当前时间
+	"now": time.Now,
+	// json 转为JSON字符串 用于图文模版中的字段 {{json .Title}}
+	"json": func(v interface{}) (string, error) {
+		bts, err := json.Marshal(v)
+		return string(bts), err
+	},
+}
+
+// truncateRunes 超过n个字时截断并以...结尾
+func truncateRunes(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string([]rune(s)[:n])
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+// msgTemplate 已解析的消息模版
+type msgTemplate struct {
+	kind TemplateKind
+	tmpl *template.Template
+}
+
+// templateSet 机器人注册的模版 目录中的模版可重新加载
+type templateSet struct {
+	mu     sync.RWMutex
+	inline map[string]*msgTemplate // 通过RegisterTemplate注册的模版
+	files  map[string]*msgTemplate // 从目录加载的模版
+	dir    string
+}
+
+func (s *templateSet) get(name string) (*msgTemplate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if t, ok := s.inline[name]; ok {
+		return t, true
+	}
+	t, ok := s.files[name]
+	return t, ok
+}
+
+func parseTemplate(name string, kind TemplateKind, text string) (*msgTemplate, error) {
+	switch kind {
+	case TemplateText, TemplateMarkdown, TemplateNews:
+	default:
+		return nil, fmt.Errorf("wxrobot: 模版[%s]的类型[%s]不支持", name, kind)
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &msgTemplate{kind: kind, tmpl: tmpl}, nil
+}
+
+// RegisterTemplate 注册名为name的消息模版 text为text/template语法 模版语法错误时返回错误
+// 同名模版会被覆盖，且优先于LoadTemplates(...)从目录加载的模版
+func (r *bot) RegisterTemplate(name string, kind TemplateKind, text string) error {
+	t, err := parseTemplate(name, kind, text)
+	if err != nil {
+		return err
+	}
+
+	r.templates.mu.Lock()
+	defer r.templates.mu.Unlock()
+	if r.templates.inline == nil {
+		r.templates.inline = make(map[string]*msgTemplate)
+	}
+	r.templates.inline[name] = t
+	return nil
+}
+
+// LoadTemplates 加载目录下的模版文件 文件名(不含扩展名)为模版名
+// .txt为文本消息 .md为markdown消息 .json为图文消息 其他文件会被忽略
+// 任一模版语法错误时返回错误，并继续使用之前加载的模版
+func (r *bot) LoadTemplates(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]*msgTemplate)
+	for _, info := range infos {
+		ext := filepath.Ext(info.Name())
+		kind, ok := templateExts[ext]
+		if info.IsDir() || !ok {
+			continue
+		}
+
+		bts, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(info.Name(), ext)
+		if _, ok = files[name]; ok {
+			return fmt.Errorf("wxrobot: 模版[%s]重复定义: %s", name, info.Name())
+		}
+		if files[name], err = parseTemplate(name, kind, string(bts)); err != nil {
+			return err
+		}
+	}
+
+	r.templates.mu.Lock()
+	r.templates.files = files
+	r.templates.dir = dir
+	r.templates.mu.Unlock()
+
+	wxRobot.logger.Info(fmt.Sprintf("机器人[%s]从%s加载了%d个模版", r.name, dir, len(files)))
+	return nil
+}
+
+// ReloadTemplates 重新加载LoadTemplates(...)设置的模版目录 无需重启即可更新模版
+func (r *bot) ReloadTemplates() error {
+	r.templates.mu.RLock()
+	dir := r.templates.dir
+	r.templates.mu.RUnlock()
+
+	if dir == "" {
+		return errors.New("wxrobot: 请先调用LoadTemplates(...)设置模版目录")
+	}
+	return r.LoadTemplates(dir)
+}
+
+// lookup 查找名为name的模版
+func (r *bot) lookup(name string) (*msgTemplate, error) {
+	t, ok := r.templates.get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return t, nil
+}
+
+// execute 使用data渲染模版
+func (t *msgTemplate) execute(data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render 使用data渲染名为name的模版 返回对应类型的消息
+// 需要继续设置消息时请使用RenderText、RenderMarkdown或RenderNews
+func (r *bot) Render(name string, data interface{}) (Sender, error) {
+	t, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := t.execute(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.kind {
+	case TemplateText:
+		return r.ToTextMsg(content), nil
+	case TemplateMarkdown:
+		return r.ToMarkdownMsg(content), nil
+	default:
+		// 不能直接返回*toMsgNews 否则出错时返回的Sender不为nil
+		news, err := r.newsFromTemplate(name, content)
+		if err != nil {
+			return nil, err
+		}
+		return news, nil
+	}
+}
+
+// RenderText 使用data渲染名为name的文本消息模版
+func (r *bot) RenderText(name string, data interface{}) (*toMsgText, error) {
+	content, err := r.renderKind(name, TemplateText, data)
+	if err != nil {
+		return nil, err
+	}
+	return r.ToTextMsg(content), nil
+}
+
+// RenderMarkdown 使用data渲染名为name的markdown消息模版
+func (r *bot) RenderMarkdown(name string, data interface{}) (*toMsgMarkdown, error) {
+	content, err := r.renderKind(name, TemplateMarkdown, data)
+	if err != nil {
+		return nil, err
+	}
+	return r.ToMarkdownMsg(content), nil
+}
+
+// RenderNews 使用data渲染名为name的图文消息模版
+func (r *bot) RenderNews(name string, data interface{}) (*toMsgNews, error) {
+	content, err := r.renderKind(name, TemplateNews, data)
+	if err != nil {
+		return nil, err
+	}
+	return r.newsFromTemplate(name, content)
+}
+
+func (r *bot) renderKind(name string, kind TemplateKind, data interface{}) (string, error) {
+	t, err := r.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if t.kind != kind {
+		return "", fmt.Errorf("wxrobot: 模版[%s]的类型为%s, 不是%s", name, t.kind, kind)
+	}
+	return t.execute(data)
+}
+
+// newsFromTemplate 图文模版渲染结果为NewsArticle的JSON数组
+func (r *bot) newsFromTemplate(name, content string) (*toMsgNews, error) {
+	var articles []*NewsArticle
+	if err := json.Unmarshal([]byte(content), &articles); err != nil {
+		return nil, fmt.Errorf("wxrobot: 图文模版[%s]应渲染为图文的JSON数组: %w", name, err)
+	}
+	return r.ToNewsMsg().Articles(articles...), nil
+}
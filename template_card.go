@@ -0,0 +1,360 @@
+package wxrobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TemplateCardType 模版卡片类型
+type TemplateCardType string
+
+const (
+	TemplateCardTextNotice TemplateCardType = "text_notice" // 文本通知模版卡片
+	TemplateCardNewsNotice TemplateCardType = "news_notice" // 图文展示模版卡片
+)
+
+var ErrInvalidTemplateCard = errors.New("wxrobot: invalid template card")
+
+// CardSource 卡片来源样式信息
+type CardSource struct {
+	IconURL   string `json:"icon_url,omitempty"`   // 来源图片的url
+	Desc      string `json:"desc,omitempty"`       // 来源图片的描述 建议不超过13个字
+	DescColor int    `json:"desc_color,omitempty"` // 来源文字的颜色 0灰色 1黑色 2红色 3绿色
+}
+
+// CardTitle 卡片的标题 用于一级标题及关键数据
+type CardTitle struct {
+	Title string `json:"title,omitempty"`
+	Desc  string `json:"desc,omitempty"`
+}
+
+// CardQuoteArea 引用文献样式
+type CardQuoteArea struct {
+	Type      int    `json:"type"`                 // 点击事件 0或不填代表没有点击事件 1跳转url 2跳转小程序
+	URL       string `json:"url,omitempty"`        // type为1时必填
+	AppID     string `json:"appid,omitempty"`      // type为2时必填
+	PagePath  string `json:"pagepath,omitempty"`   // 跳转小程序的pagepath
+	Title     string `json:"title,omitempty"`      // 引用文献样式的标题
+	QuoteText string `json:"quote_text,omitempty"` // 引用文献样式的引用文案
+}
+
+// CardHorizontalContent 二级标题+文本列表项
+type CardHorizontalContent struct {
+	KeyName string `json:"keyname"`            // 二级标题 建议不超过5个字
+	Value   string `json:"value,omitempty"`    // 二级文本 建议不超过26个字
+	Type    int    `json:"type,omitempty"`     // 链接类型 0或不填普通文本 1跳转url 2下载附件 3@员工
+	URL     string `json:"url,omitempty"`      // type为1时必填
+	MediaID string `json:"media_id,omitempty"` // type为2时必填
+	UserID  string `json:"userid,omitempty"`   // type为3时必填
+}
+
+// CardJump 跳转指引样式的列表项
+type CardJump struct {
+	Type     int    `json:"type,omitempty"`     // 跳转链接类型 0或不填不是链接 1跳转url 2跳转小程序
+	Title    string `json:"title"`              // 跳转链接样式的文案内容 建议不超过13个字
+	URL      string `json:"url,omitempty"`      // type为1时必填
+	AppID    string `json:"appid,omitempty"`    // type为2时必填
+	PagePath string `json:"pagepath,omitempty"` // 跳转小程序的pagepath
+}
+
+// CardAction 整体卡片的点击跳转事件
+type CardAction struct {
+	Type     int    `json:"type"`               // 卡片跳转类型 1跳转url 2打开小程序
+	URL      string `json:"url,omitempty"`      // type为1时必填
+	AppID    string `json:"appid,omitempty"`    // type为2时必填
+	PagePath string `json:"pagepath,omitempty"` // 跳转小程序的pagepath
+}
+
+// CardImage 图文展示卡片的图片样式
+type CardImage struct {
+	URL         string  `json:"url"`                    // 图片的url
+	AspectRatio float64 `json:"aspect_ratio,omitempty"` // 图片的宽高比 取值1.3~2.25 默认1.3
+}
+
+// CardImageTextArea 图文展示卡片的左图右文样式
+type CardImageTextArea struct {
+	Type     int    `json:"type,omitempty"`     // 点击事件 0或不填代表没有点击事件 1跳转url 2跳转小程序
+	URL      string `json:"url,omitempty"`      // type为1时必填
+	AppID    string `json:"appid,omitempty"`    // type为2时必填
+	PagePath string `json:"pagepath,omitempty"` // 跳转小程序的pagepath
+	Title    string `json:"title,omitempty"`    // 左图右文样式的标题
+	Desc     string `json:"desc,omitempty"`     // 左图右文样式的描述
+	ImageURL string `json:"image_url"`          // 左图右文样式的图片url
+}
+
+// CardVerticalContent 卡片二级垂直内容
+type CardVerticalContent struct {
+	Title string `json:"title"`          // 卡片二级标题 建议不超过26个字
+	Desc  string `json:"desc,omitempty"` // 二级普通文本 建议不超过112个字
+}
+
+// toMsgTemplateCard 模版卡片消息
+type toMsgTemplateCard struct {
+	toBaseMsg
+	CardType              TemplateCardType         `json:"card_type"`
+	Source_               *CardSource              `json:"source,omitempty"`
+	MainTitle_            *CardTitle               `json:"main_title,omitempty"`
+	EmphasisContent_      *CardTitle               `json:"emphasis_content,omitempty"`
+	QuoteArea_            *CardQuoteArea           `json:"quote_area,omitempty"`
+	SubTitleText_         string                   `json:"sub_title_text,omitempty"`
+	HorizontalContentList []*CardHorizontalContent `json:"horizontal_content_list,omitempty"`
+	JumpList              []*CardJump              `json:"jump_list,omitempty"`
+	CardAction_           *CardAction              `json:"card_action,omitempty"`
+	CardImage_            *CardImage               `json:"card_image,omitempty"`
+	ImageTextArea_        *CardImageTextArea       `json:"image_text_area,omitempty"`
+	VerticalContentList   []*CardVerticalContent   `json:"vertical_content_list,omitempty"`
+}
+
+// Source 卡片来源样式信息 descColor 0灰色 1黑色 2红色 3绿色
+func (tm *toMsgTemplateCard) Source(iconURL, desc string, descColor int) *toMsgTemplateCard {
+	tm.Source_ = &CardSource{IconURL: iconURL, Desc: desc, DescColor: descColor}
+	return tm
+}
+
+// MainTitle 一级标题 title建议不超过26个字 desc建议不超过30个字
+func (tm *toMsgTemplateCard) MainTitle(title, desc string) *toMsgTemplateCard {
+	tm.MainTitle_ = &CardTitle{Title: title, Desc: desc}
+	return tm
+}
+
+// EmphasisContent 关键数据样式 仅text_notice支持 title建议不超过10个字 desc建议不超过15个字
+func (tm *toMsgTemplateCard) EmphasisContent(title, desc string) *toMsgTemplateCard {
+	tm.EmphasisContent_ = &CardTitle{Title: title, Desc: desc}
+	return tm
+}
+
+// QuoteArea 引用文献样式
+func (tm *toMsgTemplateCard) QuoteArea(quote *CardQuoteArea) *toMsgTemplateCard {
+	tm.QuoteArea_ = quote
+	return tm
+}
+
+// SubTitleText 二级普通文本 仅text_notice支持 建议不超过112个字
+func (tm *toMsgTemplateCard) SubTitleText(text string) *toMsgTemplateCard {
+	tm.SubTitleText_ = text
+	return tm
+}
+
+// HorizontalContent 二级标题+文本列表 列表长度不超过6
+func (tm *toMsgTemplateCard) HorizontalContent(items ...*CardHorizontalContent) *toMsgTemplateCard {
+	tm.HorizontalContentList = append(tm.HorizontalContentList, items...)
+	return tm
+}
+
+// Jump 跳转指引样式的列表 列表长度不超过3
+func (tm *toMsgTemplateCard) Jump(items ...*CardJump) *toMsgTemplateCard {
+	tm.JumpList = append(tm.JumpList, items...)
+	return tm
+}
+
+// CardAction 整体卡片的点击跳转事件 必填
+func (tm *toMsgTemplateCard) CardAction(action *CardAction) *toMsgTemplateCard {
+	tm.CardAction_ = action
+	return tm
+}
+
+// CardImage 图片样式 仅news_notice支持 aspectRatio为图片宽高比 取值1.3~2.25 传0使用默认值1.3
+func (tm *toMsgTemplateCard) CardImage(url string, aspectRatio float64) *toMsgTemplateCard {
+	tm.CardImage_ = &CardImage{URL: url, AspectRatio: aspectRatio}
+	return tm
+}
+
+// ImageTextArea 左图右文样式 仅news_notice支持
+func (tm *toMsgTemplateCard) ImageTextArea(area *CardImageTextArea) *toMsgTemplateCard {
+	tm.ImageTextArea_ = area
+	return tm
+}
+
+// VerticalContent 卡片二级垂直内容 仅news_notice支持 列表长度不超过4
+func (tm *toMsgTemplateCard) VerticalContent(items ...*CardVerticalContent) *toMsgTemplateCard {
+	tm.VerticalContentList = append(tm.VerticalContentList, items...)
+	return tm
+}
+
+// ChatId
+/*
+会话id，支持最多传100个。
+可能是群聊会话，也可能是单聊会话或者小黑板会话，通过消息回调获得，也可以是userid。
+特殊的，当chatid为“@all_group”时，表示对所有群广播，为“@all_subscriber”时表示对订阅范围内员工广播单聊消息，
+为“@all_blackboard”时，表示对所有小黑板广播，为“@all”时，表示对所有群、所有订阅范围
+*/
+func (tm *toMsgTemplateCard) ChatId(chatId ...string) *toMsgTemplateCard {
+	tm.chatId(chatId...)
+	return tm
+}
+
+// Visible
+// 该消息只有指定的群成员或小黑板成员可见（其他成员不可见），有且只有chatid指定了一个群或一个小黑板的时候生效
+func (tm *toMsgTemplateCard) Visible(user ...string) *toMsgTemplateCard {
+	tm.visible(user...)
+	return tm
+}
+
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgTemplateCard) TTL(ttl time.Duration) *toMsgTemplateCard {
+	tm.ttl = ttl
+	return tm
+}
+
+// Send 发送消息
+func (tm *toMsgTemplateCard) Send() error {
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送
+func (tm *toMsgTemplateCard) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.TemplateCard = tm
+	return cmsg.send(ctx)
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+func (tm *toMsgTemplateCard) SendAsync() (*AsyncResult, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.TemplateCard = tm
+	return cmsg.sendAsync()
+}
+
+// Validate 按企业微信文档的限制校验卡片内容
+// 只校验必填字段、列表长度及取值范围，文档中"建议不超过N个字"的字段超出时由企业微信截断展示，不会返回错误
+func (tm *toMsgTemplateCard) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+
+	news := tm.CardType == TemplateCardNewsNotice
+	if tm.CardType != TemplateCardTextNotice && !news {
+		return cardErrorf("card_type", "不支持的卡片类型[%s]", tm.CardType)
+	}
+
+	var first error
+	check := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	if s := tm.Source_; s != nil && (s.DescColor < 0 || s.DescColor > 3) {
+		check(cardErrorf("source.desc_color", "取值为0~3, 当前为%d", s.DescColor))
+	}
+
+	if (tm.MainTitle_ == nil || tm.MainTitle_.Title == "") && (news || tm.SubTitleText_ == "") {
+		check(cardErrorf("main_title.title", "不能为空"))
+	}
+
+	if tm.EmphasisContent_ != nil && news {
+		check(cardErrorf("emphasis_content", "仅text_notice卡片支持"))
+	}
+
+	if tm.SubTitleText_ != "" && news {
+		check(cardErrorf("sub_title_text", "仅text_notice卡片支持"))
+	}
+
+	if q := tm.QuoteArea_; q != nil {
+		check(checkLink("quote_area", q.Type, q.URL, q.AppID))
+	}
+
+	if len(tm.HorizontalContentList) > 6 {
+		check(cardErrorf("horizontal_content_list", "列表长度不超过6, 当前为%d", len(tm.HorizontalContentList)))
+	}
+	for i, h := range tm.HorizontalContentList {
+		field := fmt.Sprintf("horizontal_content_list[%d]", i)
+		switch {
+		case h == nil:
+			check(cardErrorf(field, "不能为空"))
+		case h.KeyName == "":
+			check(cardErrorf(field+".keyname", "不能为空"))
+		case h.Type < 0 || h.Type > 3:
+			check(cardErrorf(field+".type", "取值为0~3, 当前为%d", h.Type))
+		case h.Type == 1 && h.URL == "":
+			check(cardErrorf(field+".url", "type为1时必填"))
+		case h.Type == 2 && h.MediaID == "":
+			check(cardErrorf(field+".media_id", "type为2时必填"))
+		case h.Type == 3 && h.UserID == "":
+			check(cardErrorf(field+".userid", "type为3时必填"))
+		}
+	}
+
+	if len(tm.JumpList) > 3 {
+		check(cardErrorf("jump_list", "列表长度不超过3, 当前为%d", len(tm.JumpList)))
+	}
+	for i, j := range tm.JumpList {
+		field := fmt.Sprintf("jump_list[%d]", i)
+		if j == nil {
+			check(cardErrorf(field, "不能为空"))
+			continue
+		}
+		if j.Title == "" {
+			check(cardErrorf(field+".title", "不能为空"))
+		}
+		check(checkLink(field, j.Type, j.URL, j.AppID))
+	}
+
+	if a := tm.CardAction_; a == nil {
+		check(cardErrorf("card_action", "不能为空"))
+	} else if a.Type != 1 && a.Type != 2 {
+		check(cardErrorf("card_action.type", "取值为1或2, 当前为%d", a.Type))
+	} else {
+		check(checkLink("card_action", a.Type, a.URL, a.AppID))
+	}
+
+	if news {
+		if tm.CardImage_ == nil && tm.ImageTextArea_ == nil {
+			check(cardErrorf("card_image", "news_notice卡片的card_image和image_text_area必须填写一项"))
+		}
+		if img := tm.CardImage_; img != nil {
+			if img.URL == "" {
+				check(cardErrorf("card_image.url", "不能为空"))
+			}
+			if img.AspectRatio != 0 && (img.AspectRatio < 1.3 || img.AspectRatio > 2.25) {
+				check(cardErrorf("card_image.aspect_ratio", "取值为1.3~2.25, 当前为%v", img.AspectRatio))
+			}
+		}
+		if area := tm.ImageTextArea_; area != nil {
+			if area.ImageURL == "" {
+				check(cardErrorf("image_text_area.image_url", "不能为空"))
+			}
+			check(checkLink("image_text_area", area.Type, area.URL, area.AppID))
+		}
+		if len(tm.VerticalContentList) > 4 {
+			check(cardErrorf("vertical_content_list", "列表长度不超过4, 当前为%d", len(tm.VerticalContentList)))
+		}
+		for i, v := range tm.VerticalContentList {
+			field := fmt.Sprintf("vertical_content_list[%d]", i)
+			if v == nil {
+				check(cardErrorf(field, "不能为空"))
+			} else if v.Title == "" {
+				check(cardErrorf(field+".title", "不能为空"))
+			}
+		}
+	} else if tm.CardImage_ != nil || tm.ImageTextArea_ != nil || len(tm.VerticalContentList) > 0 {
+		check(cardErrorf("card_image", "card_image、image_text_area、vertical_content_list仅news_notice卡片支持"))
+	}
+
+	return first
+}
+
+func cardErrorf(field, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s %s", ErrInvalidTemplateCard, field, fmt.Sprintf(format, args...))
+}
+
+// checkLink 校验跳转类型对应的必填字段 1跳转url 2跳转小程序
+func checkLink(field string, typ int, url, appID string) error {
+	switch {
+	case typ < 0 || typ > 2:
+		return cardErrorf(field+".type", "取值为0~2, 当前为%d", typ)
+	case typ == 1 && url == "":
+		return cardErrorf(field+".url", "type为1时必填")
+	case typ == 2 && appID == "":
+		return cardErrorf(field+".appid", "type为2时必填")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package wxrobot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTemplateCardValidate(t *testing.T) {
+	b := Bot("template-card-test").WebhookURL("http://127.0.0.1/send?key=test")
+	action := &CardAction{Type: 1, URL: "https://work.weixin.qq.com/"}
+	textCard := func() *toMsgTemplateCard {
+		return b.ToTemplateCardMsg(TemplateCardTextNotice).MainTitle("今日值班", "").CardAction(action)
+	}
+	newsCard := func() *toMsgTemplateCard {
+		return b.ToTemplateCardMsg(TemplateCardNewsNotice).MainTitle("发布公告", "").CardAction(action)
+	}
+
+	tests := []struct {
+		name    string
+		card    *toMsgTemplateCard
+		wantErr string
+	}{
+		{"text ok", textCard().EmphasisContent("3", "未处理告警").
+			HorizontalContent(&CardHorizontalContent{KeyName: "值班人", Value: "张三"}).
+			Jump(&CardJump{Type: 1, Title: "告警详情", URL: "https://work.weixin.qq.com/"}), ""},
+		{"news ok", newsCard().CardImage("https://example.com/a.png", 1.5).
+			VerticalContent(&CardVerticalContent{Title: "版本", Desc: "v1.2.0"}), ""},
+		// 建议的字数限制由企业微信截断展示 不校验
+		{"long keyname", textCard().HorizontalContent(&CardHorizontalContent{KeyName: "值班负责人员", Value: strings.Repeat("长", 100)}), ""},
+		{"long title", textCard().MainTitle(strings.Repeat("标题", 30), strings.Repeat("描述", 30)), ""},
+		{"sub title only", b.ToTemplateCardMsg(TemplateCardTextNotice).SubTitleText("值班时间").CardAction(action), ""},
+		{"unknown type", b.ToTemplateCardMsg("vote_interaction").CardAction(action), "card_type"},
+		{"missing title", b.ToTemplateCardMsg(TemplateCardTextNotice).CardAction(action), "main_title.title"},
+		{"missing action", b.ToTemplateCardMsg(TemplateCardTextNotice).MainTitle("标题", ""), "card_action"},
+		{"bad action type", textCard().CardAction(&CardAction{Type: 3}), "card_action.type"},
+		{"action without url", textCard().CardAction(&CardAction{Type: 1}), "card_action.url"},
+		{"bad desc color", textCard().Source("", "来源", 4), "source.desc_color"},
+		{"nil horizontal content", textCard().HorizontalContent(nil), "horizontal_content_list[0]"},
+		{"empty keyname", textCard().HorizontalContent(&CardHorizontalContent{Value: "张三"}), "keyname"},
+		{"userid required", textCard().HorizontalContent(&CardHorizontalContent{KeyName: "负责人", Type: 3}), "userid"},
+		{"too many horizontal", textCard().HorizontalContent(make([]*CardHorizontalContent, 7)...), "列表长度不超过6"},
+		{"nil jump", textCard().Jump(nil), "jump_list[0]"},
+		{"jump appid required", textCard().Jump(&CardJump{Type: 2, Title: "小程序"}), "jump_list[0].appid"},
+		{"emphasis in news", newsCard().CardImage("https://example.com/a.png", 0).EmphasisContent("3", ""), "emphasis_content"},
+		{"news without image", newsCard(), "card_image"},
+		{"bad aspect ratio", newsCard().CardImage("https://example.com/a.png", 3), "aspect_ratio"},
+		{"nil vertical content", newsCard().CardImage("https://example.com/a.png", 0).VerticalContent(nil), "vertical_content_list[0]"},
+		{"image in text card", textCard().CardImage("https://example.com/a.png", 0), "仅news_notice卡片支持"},
+	}
+	for _, tt := range tests {
+		err := tt.card.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantErr != "" && (!errors.Is(err, ErrInvalidTemplateCard) || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want ErrInvalidTemplateCard containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package wxrobot
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	b := Bot("template-test").WebhookURL("http://127.0.0.1/send?key=test")
+	for _, tmpl := range []struct {
+		name string
+		kind TemplateKind
+		text string
+	}{
+		{"release", TemplateText, "{{.Service}} {{.Version}}已发布"},
+		{"alert", TemplateMarkdown, "{{warning .Service}}告警"},
+		{"news", TemplateNews, `[{"title":"{{.Service}}","url":"https://example.com"}]`},
+		{"bad-news", TemplateNews, `{{.Service}}`},
+	} {
+		if err := b.RegisterTemplate(tmpl.name, tmpl.kind, tmpl.text); err != nil {
+			t.Fatalf("RegisterTemplate(%s): %v", tmpl.name, err)
+		}
+	}
+	if err := b.RegisterTemplate("broken", TemplateText, "{{.Service"); err == nil {
+		t.Error("RegisterTemplate with syntax error should fail")
+	}
+
+	data := map[string]string{"Service": "api", "Version": "v1.2"}
+	tests := []struct {
+		name    string
+		want    string // 消息的JSON
+		wantErr error
+	}{
+		{"release", `{"msgtype":"text","text":{"content":"api v1.2已发布","mentioned_list":null,"mentioned_mobile_list":null}}`, nil},
+		{"news", `{"msgtype":"news","news":{"articles":[{"title":"api","description":"","url":"https://example.com","picurl":""}]}}`, nil},
+		{"missing", "", ErrTemplateNotFound},
+	}
+	for _, tt := range tests {
+		msg, err := b.Render(tt.name, data)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr != nil {
+			continue
+		}
+		if bt, err := msg.(interface{ Payload() ([]byte, error) }).Payload(); err != nil || string(bt) != tt.want {
+			t.Errorf("%s: payload = %s, %v, want %s", tt.name, bt, err, tt.want)
+		}
+	}
+
+	// 渲染失败时返回的Sender必须为nil 避免调用方判断msg != nil后发送
+	msg, err := b.Render("bad-news", data)
+	if err == nil || msg != nil {
+		t.Errorf("Render(bad-news) = %#v, %v, want nil Sender and error", msg, err)
+	}
+	if md, err := b.Render("alert", data); err != nil || md == nil {
+		t.Errorf("Render(alert) = %v, %v", md, err)
+	}
+}
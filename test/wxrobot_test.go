@@ -184,6 +184,28 @@ func TestSendMarkdown(t *testing.T) {
 
 }
 
+func TestSendMarkdownBuilder(t *testing.T) {
+	bot.SwitchDebugMode(true)
+	user := "<font color=\"info\">张三</font>"
+	md := wxrobot.NewMarkdown().
+		Heading(2, "2019公司文化衫尺码收集").
+		Text("范围：所有").Warning("正式员工+实习生").NewLine().
+		Text("提交人：").Bold(user).Text(" ").Mention("zhangsan").NewLine().
+		Quote("服装：统一为蓝色logo+白色T").
+		Link("查看详情", "https://work.weixin.qq.com/?a=(1)")
+	_ = bot.ToMarkdownMsg(md.String()).Send()
+}
+
+func TestSendMarkdownV2(t *testing.T) {
+	bot.SwitchDebugMode(true)
+	table := wxrobot.MarkdownV2Table([][]string{
+		{"尺码", "人数"},
+		{"M", "12"},
+		{"L", "8"},
+	})
+	_ = bot.ToMarkdownV2Msg("# 2019公司文化衫尺码统计\n\n" + table + "\n```\n统计截止至9月1日\n```").Send()
+}
+
 //go:embed test.png
 var img []byte
 
@@ -218,3 +240,17 @@ func TestSendFile(t *testing.T) {
 	bot.SwitchDebugMode(true)
 	_ = bot.ToFileMsg().File("test.docx", file).Send()
 }
+
+func TestSendTemplateCard(t *testing.T) {
+	bot.SwitchDebugMode(true)
+	_ = bot.ToTemplateCardMsg(wxrobot.TemplateCardTextNotice).
+		Source("https://wework.qpic.cn/wwpic/252813_jOfDHtcISzuodLa_1629280209/0", "值班通知", 0).
+		MainTitle("今日值班安排", "请值班同学及时处理告警").
+		EmphasisContent("3", "未处理告警").
+		SubTitleText("值班时间 09:00-21:00").
+		HorizontalContent(&wxrobot.CardHorizontalContent{KeyName: "值班人", Value: "张三"},
+			&wxrobot.CardHorizontalContent{KeyName: "值班文档", Value: "点击查看", Type: 1, URL: "https://work.weixin.qq.com/"}).
+		Jump(&wxrobot.CardJump{Type: 1, Title: "告警详情", URL: "https://work.weixin.qq.com/"}).
+		CardAction(&wxrobot.CardAction{Type: 1, URL: "https://work.weixin.qq.com/"}).
+		Send()
+}
@@ -2,16 +2,18 @@ package wxrobot
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"hash"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 )
 
 var md5Pool sync.Pool
@@ -25,15 +27,22 @@ func init() {
 // toCommonMsg 回复消息
 type toCommonMsg struct {
 	*bot
-	MsgType       string         `json:"msgtype"`
-	ChatID        string         `json:"chatid,omitempty"`
-	PostId        string         `json:"post_id,omitempty"`
-	VisibleToUser string         `json:"visible_to_user,omitempty"`
-	Text          *toMsgText     `json:"text,omitempty"`
-	Markdown      *toMsgMarkdown `json:"markdown,omitempty"`
-	News          *toMsgNews     `json:"news,omitempty"`
-	Image         *toMsgImage    `json:"image,omitempty"`
-	File          *toMsgFile     `json:"file,omitempty"`
+	ttl            time.Duration
+	outboxID       string
+	idempotencyKey string
+
+	MsgType       string             `json:"msgtype"`
+	ChatID        string             `json:"chatid,omitempty"`
+	PostId        string             `json:"post_id,omitempty"`
+	VisibleToUser string             `json:"visible_to_user,omitempty"`
+	Text          *toMsgText         `json:"text,omitempty"`
+	Markdown      *toMsgMarkdown     `json:"markdown,omitempty"`
+	MarkdownV2    *toMsgMarkdownV2   `json:"markdown_v2,omitempty"`
+	News          *toMsgNews         `json:"news,omitempty"`
+	Image         *toMsgImage        `json:"image,omitempty"`
+	File          *toMsgFile         `json:"file,omitempty"`
+	Voice         *toMsgVoice        `json:"voice,omitempty"`
+	TemplateCard  *toMsgTemplateCard `json:"template_card,omitempty"`
 }
 
 // sendResponse 推送消息响应
@@ -42,21 +51,134 @@ type sendResponse struct {
 	ErrMsg  string `json:"errmsg"`
 }
 
-func (tc *toCommonMsg) send() error {
-	bt, err := json.Marshal(tc)
+// send 发送消息 异步模式下仅放入队列
+func (tc *toCommonMsg) send(ctx context.Context) error {
+	if ok, err := tc.reserve(); !ok {
+		return err
+	}
+	if err := tc.persist(); err != nil {
+		tc.release()
+		return err
+	}
+	if q := tc.bot.currentQueue(); q != nil {
+		_, err := q.enqueue(tc)
+		if err != nil {
+			tc.release()
+			tc.discard()
+		}
+		return err
+	}
+	err := tc.deliver(ctx)
+	tc.finishOutbox(err)
+	return err
+}
+
+// sendAsync 将消息放入异步队列
+func (tc *toCommonMsg) sendAsync() (*AsyncResult, error) {
+	q := tc.bot.currentQueue()
+	if q == nil {
+		return nil, ErrAsyncDisabled
+	}
+	if ok, err := tc.reserve(); !ok {
+		if err != nil {
+			return nil, err
+		}
+		// 重复的消息直接返回发送成功
+		res := &AsyncResult{done: make(chan struct{})}
+		res.finish(nil)
+		return res, nil
+	}
+	if err := tc.persist(); err != nil {
+		tc.release()
+		return nil, err
+	}
+	res, err := q.enqueue(tc)
 	if err != nil {
+		tc.release()
+		tc.discard()
+	}
+	return res, err
+}
+
+// persist 开启outbox时 发送前先将消息写入磁盘
+func (tc *toCommonMsg) persist() error {
+	if tc.bot.outbox == nil {
+		return nil
+	}
+
+	entry, err := newOutboxEntry(tc)
+	if err != nil {
+		return err
+	}
+	if err = tc.bot.outbox.save(entry); err != nil {
 		return err
 	}
+	tc.outboxID = entry.ID
+	return nil
+}
 
-	webhookURL := tc.webhookURL
-	if tc.debug {
-		webhookURL += "&debug=1"
+// deliver 发送消息 文件消息会先上传文件
+func (tc *toCommonMsg) deliver(ctx context.Context) error {
+	err := tc.transmit(ctx)
+	if err != nil {
+		tc.release()
 	}
+	return err
+}
+
+func (tc *toCommonMsg) transmit(ctx context.Context) error {
+	if tc.File != nil && tc.File.MediaID == "" {
+		media, err := tc.File.uploadMedia(ctx)
+		if err != nil {
+			return err
+		}
+		tc.File.MediaID = media.ID
+	}
+	if tc.Voice != nil && tc.Voice.MediaID == "" {
+		media, err := tc.bot.uploadMedia(ctx, tc.Voice.fileName, tc.Voice.fileContent, MediaTypeVoice)
+		if err != nil {
+			return err
+		}
+		tc.Voice.MediaID = media.ID
+	}
+
+	bt, err := tc.payload()
+	if err != nil {
+		return err
+	}
+
 	wxRobot.logger.Debug("send json", string(bt))
-	res, err := wxRobot.httpClient.Post(webhookURL, "application/json", bytes.NewReader(bt))
+	return tc.bot.withRetry(ctx, "发送消息", func() error {
+		return tc.bot.post(ctx, bt)
+	})
+}
+
+// post 向webhook推送一次消息
+func (r *bot) post(ctx context.Context, bt []byte) error {
+	if r.dryRun {
+		return r.dryRunPost(bt)
+	}
+	if r.limiter != nil {
+		if err := r.limiter.acquire(ctx, r.webhookURL); err != nil {
+			return err
+		}
+	}
+
+	webhookURL := r.webhookURL
+	if r.debug {
+		webhookURL += "&debug=1"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(bt))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	res, err := wxRobot.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -64,15 +186,10 @@ func (tc *toCommonMsg) send() error {
 	}
 
 	var sendRes sendResponse
-	_ = json.Unmarshal(body, &sendRes)
-
-	wxRobot.logger.Debug("send return ", sendRes)
-
-	if sendRes.ErrCode != 0 {
-		return fmt.Errorf("%v", sendRes)
-	}
+	err = parseResponse(res.StatusCode, body, &sendRes)
+	wxRobot.logger.Debug("send return ", res.StatusCode, string(body))
 
-	return nil
+	return err
 }
 
 type toBaseMsg struct {
@@ -81,6 +198,9 @@ type toBaseMsg struct {
 	visibleToUsers []string
 	chatids        []string
 	postId         string
+	ttl            time.Duration
+	chatType       ChatType // 由回调消息创建时的会话类型 用于校验
+	idempotencyKey string
 }
 
 func (t *toBaseMsg) chatId(chatId ...string) {
@@ -96,6 +216,8 @@ func (t *toBaseMsg) buildCommonMsg() *toCommonMsg {
 	cmsg.bot = t.bot
 	cmsg.MsgType = t.msgType
 	cmsg.PostId = t.postId
+	cmsg.ttl = t.ttl
+	cmsg.idempotencyKey = t.idempotencyKey
 	cmsg.ChatID = strings.Join(t.chatids, "|")
 	cmsg.VisibleToUser = strings.Join(t.visibleToUsers, "|")
 
@@ -105,6 +227,8 @@ func (t *toBaseMsg) buildCommonMsg() *toCommonMsg {
 // toMsgText 文本消息类型
 type toMsgText struct {
 	toBaseMsg
+	split               bool
+	splitNumbered       bool
 	Content             string   `json:"content"`
 	MentionedList       []string `json:"mentioned_list"`
 	MentionedMobileList []string `json:"mentioned_mobile_list"`
@@ -147,19 +271,86 @@ func (t *toMsgText) Visible(user ...string) *toMsgText {
 	return t
 }
 
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (t *toMsgText) TTL(ttl time.Duration) *toMsgText {
+	t.ttl = ttl
+	return t
+}
+
 // Send 发送消息
 func (t *toMsgText) Send() error {
-	cmsg := t.buildCommonMsg()
-	cmsg.Text = t
-	return cmsg.send()
+	return t.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送
+func (t *toMsgText) SendContext(ctx context.Context) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	for _, part := range t.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Text = part
+		if err := cmsg.send(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+// 消息被拆分时返回最后一段的发送结果
+func (t *toMsgText) SendAsync() (res *AsyncResult, err error) {
+	if err = t.Validate(); err != nil {
+		return nil, err
+	}
+	for _, part := range t.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Text = part
+		if res, err = cmsg.sendAsync(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Split 内容超过2048字节时自动拆分为多条消息依次发送
+// 拆分优先在段落和换行处进行，@的用户只在第一条消息中提醒 numbered为true时在每段前加上(1/3)形式的编号
+func (t *toMsgText) Split(numbered bool) *toMsgText {
+	t.split = true
+	t.splitNumbered = numbered
+	return t
+}
+
+// parts 按拆分设置返回实际要发送的消息
+func (t *toMsgText) parts() []*toMsgText {
+	if !t.split || len(t.Content) <= MaxTextBytes {
+		return []*toMsgText{t}
+	}
+
+	// 纯文本总能拆分
+	contents, _ := splitContent(t.Content, MaxTextBytes, false, t.splitNumbered)
+	parts := make([]*toMsgText, len(contents))
+	for i, content := range contents {
+		part := *t
+		part.Content = content
+		if i > 0 {
+			part.MentionedList = nil
+			part.MentionedMobileList = nil
+		}
+		part.idempotencyKey = partKey(t.idempotencyKey, i)
+		parts[i] = &part
+	}
+	return parts
 }
 
 // toMsgMarkdown markdown消息类型
 type toMsgMarkdown struct {
 	toBaseMsg
-	Content     string           `xml:"Content" json:"content"`
-	ShortName   bool             `json:"at_short_name"`
-	Attachments []*MsgAttachment `json:"attachments,omitempty"`
+	split         bool
+	splitNumbered bool
+	Content       string           `xml:"Content" json:"content"`
+	ShortName     bool             `json:"at_short_name"`
+	Attachments   []*MsgAttachment `json:"attachments,omitempty"`
 }
 
 // PostId 小黑板帖子id，当前消息为小黑板回帖消息时带上，有且只有chatid指定了一个小黑板的时候生效
@@ -200,11 +391,80 @@ func (tm *toMsgMarkdown) Visible(user ...string) *toMsgMarkdown {
 	return tm
 }
 
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgMarkdown) TTL(ttl time.Duration) *toMsgMarkdown {
+	tm.ttl = ttl
+	return tm
+}
+
 // Send 发送消息
 func (tm *toMsgMarkdown) Send() error {
-	cmsg := tm.buildCommonMsg()
-	cmsg.Markdown = tm
-	return cmsg.send()
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送
+func (tm *toMsgMarkdown) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
+	for _, part := range tm.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Markdown = part
+		if err := cmsg.send(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+// 消息被拆分时返回最后一段的发送结果
+func (tm *toMsgMarkdown) SendAsync() (res *AsyncResult, err error) {
+	if err = tm.Validate(); err != nil {
+		return nil, err
+	}
+	for _, part := range tm.parts() {
+		cmsg := part.buildCommonMsg()
+		cmsg.Markdown = part
+		if res, err = cmsg.sendAsync(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Split 内容超过4096字节时自动拆分为多条消息依次发送
+// 拆分优先在段落和换行处进行，不会拆断<font>标签、链接等markdown结构，attachments只在最后一条消息中发送
+// 超长的<font>及加粗内容在拆分处闭合并在下一条重新打开，超长的链接及行内代码无法拆分，Validate返回ErrContentTooLong
+// numbered为true时在每段前加上(1/3)形式的编号
+func (tm *toMsgMarkdown) Split(numbered bool) *toMsgMarkdown {
+	tm.split = true
+	tm.splitNumbered = numbered
+	return tm
+}
+
+// parts 按拆分设置返回实际要发送的消息
+func (tm *toMsgMarkdown) parts() []*toMsgMarkdown {
+	if !tm.split || len(tm.Content) <= MaxMarkdownBytes {
+		return []*toMsgMarkdown{tm}
+	}
+
+	// Validate已校验过能否拆分 无法拆分时原样发送
+	contents, err := splitContent(tm.Content, MaxMarkdownBytes, true, tm.splitNumbered)
+	if err != nil {
+		return []*toMsgMarkdown{tm}
+	}
+	parts := make([]*toMsgMarkdown, len(contents))
+	for i, content := range contents {
+		part := *tm
+		part.Content = content
+		if i < len(contents)-1 {
+			part.Attachments = nil
+		}
+		part.idempotencyKey = partKey(tm.idempotencyKey, i)
+		parts[i] = &part
+	}
+	return parts
 }
 
 // toMsgNews 图文消息
@@ -246,22 +506,57 @@ func (tm *toMsgNews) Visible(user ...string) *toMsgNews {
 	return tm
 }
 
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgNews) TTL(ttl time.Duration) *toMsgNews {
+	tm.ttl = ttl
+	return tm
+}
+
 // Send 发送消息
 func (tm *toMsgNews) Send() error {
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送
+func (tm *toMsgNews) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
 	cmsg := tm.buildCommonMsg()
 	cmsg.News = tm
-	return cmsg.send()
+	return cmsg.send(ctx)
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+func (tm *toMsgNews) SendAsync() (*AsyncResult, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.News = tm
+	return cmsg.sendAsync()
 }
 
 // toMsgImage 图片消息
 type toMsgImage struct {
 	toBaseMsg
-	Base64 string `json:"base64"`
-	MD5    string `json:"md5"`
+	Base64      string `json:"base64"`
+	MD5         string `json:"md5"`
+	raw         []byte // 待校验的原始图片
+	autoConvert bool
+	err         error
 }
 
 // Image 图片最大不能超过2M，支持JPG,PNG格式
+// 图片在Send时校验，不满足要求时Send返回错误，调用AutoConvert()可自动转换
 func (tm *toMsgImage) Image(bts []byte) *toMsgImage {
+	tm.raw = bts
+	tm.err = nil
+	tm.setImage(bts)
+	return tm
+}
+
+func (tm *toMsgImage) setImage(bts []byte) {
 	// 计算文件base64
 	base64Str := base64.StdEncoding.EncodeToString(bts)
 	// 计算文件md5
@@ -272,7 +567,6 @@ func (tm *toMsgImage) Image(bts []byte) *toMsgImage {
 	md5Pool.Put(md)
 	tm.Base64 = base64Str
 	tm.MD5 = md5Str
-	return tm
 }
 
 // ChatId
@@ -294,27 +588,61 @@ func (tm *toMsgImage) Visible(user ...string) *toMsgImage {
 	return tm
 }
 
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgImage) TTL(ttl time.Duration) *toMsgImage {
+	tm.ttl = ttl
+	return tm
+}
+
 // Send 发送消息
 func (tm *toMsgImage) Send() error {
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送
+func (tm *toMsgImage) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
 	cmsg := tm.buildCommonMsg()
 	cmsg.Image = tm
-	return cmsg.send()
+	return cmsg.send(ctx)
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+func (tm *toMsgImage) SendAsync() (*AsyncResult, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Image = tm
+	return cmsg.sendAsync()
 }
 
 // toMsgFile 文件消息
 type toMsgFile struct {
 	toBaseMsg
-	MediaID string `xml:"-" json:"media_id"`
+	MediaID     string `xml:"-" json:"media_id"`
+	fileName    string
+	fileContent []byte
+	filePath    string    // FileFromPath(...)指定的文件路径
+	fileReader  io.Reader // FileFromReader(...)指定的文件内容
+	err         error
+}
+
+// MediaId 发送已上传的文件 id为UploadMedia(...)返回的Media.ID
+func (tm *toMsgFile) MediaId(id string) *toMsgFile {
+	tm.reset()
+	tm.MediaID = id
+	return tm
 }
 
 // File 要求文件大小在5B~20M之间
+// 文件在Send/SendContext时上传，上传同样受SendContext的ctx控制
 func (tm *toMsgFile) File(fileName string, bts []byte) *toMsgFile {
-	upLoadRes, err := tm.uploadFile(fileName, bts)
-	if err != nil {
-		return tm
-	}
-
-	tm.MediaID = upLoadRes.MediaId
+	tm.reset()
+	tm.fileName = fileName
+	tm.fileContent = bts
 	return tm
 }
 
@@ -337,14 +665,124 @@ func (tm *toMsgFile) Visible(user ...string) *toMsgFile {
 	return tm
 }
 
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgFile) TTL(ttl time.Duration) *toMsgFile {
+	tm.ttl = ttl
+	return tm
+}
+
 // Send 发送消息
 func (tm *toMsgFile) Send() error {
-	if tm.MediaID == "" {
-		return fmt.Errorf("请先调用File(...)方法上传文件， 或检查日志是否上传失败")
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送，包括File(...)指定文件的上传
+func (tm *toMsgFile) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.File = tm
+	return cmsg.send(ctx)
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+func (tm *toMsgFile) SendAsync() (*AsyncResult, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
 	}
 	cmsg := tm.buildCommonMsg()
 	cmsg.File = tm
-	return cmsg.send()
+	return cmsg.sendAsync()
+}
+
+// toMsgVoice 语音消息
+type toMsgVoice struct {
+	toBaseMsg
+	MediaID     string `xml:"-" json:"media_id"`
+	fileName    string
+	fileContent []byte
+	err         error
+}
+
+// MediaId 发送已上传的语音 id为UploadMedia(..., MediaTypeVoice)返回的Media.ID
+func (tm *toMsgVoice) MediaId(id string) *toMsgVoice {
+	tm.MediaID = id
+	tm.fileName = ""
+	tm.fileContent = nil
+	tm.err = nil
+	return tm
+}
+
+// Voice 要求为amr格式 大小在5B~2M之间 播放时长不超过60s
+// 语音在Send/SendContext时上传，不满足要求时Send返回错误
+func (tm *toMsgVoice) Voice(fileName string, bts []byte) *toMsgVoice {
+	tm.MediaID = ""
+	tm.fileName = fileName
+	tm.fileContent = bts
+	tm.err = checkVoice(fileName, bts)
+	return tm
+}
+
+// ChatId
+/*
+会话id，支持最多传100个。
+可能是群聊会话，也可能是单聊会话或者小黑板会话，通过消息回调获得，也可以是userid。
+特殊的，当chatid为“@all_group”时，表示对所有群广播，为“@all_subscriber”时表示对订阅范围内员工广播单聊消息，
+为“@all_blackboard”时，表示对所有小黑板广播，为“@all”时，表示对所有群、所有订阅范围
+*/
+func (tm *toMsgVoice) ChatId(chatId ...string) *toMsgVoice {
+	tm.chatId(chatId...)
+	return tm
+}
+
+// Visible
+// 该消息只有指定的群成员或小黑板成员可见（其他成员不可见），有且只有chatid指定了一个群或一个小黑板的时候生效
+func (tm *toMsgVoice) Visible(user ...string) *toMsgVoice {
+	tm.visible(user...)
+	return tm
+}
+
+// TTL 异步模式下消息在队列中的有效期 超过有效期仍未发送的消息会被丢弃
+func (tm *toMsgVoice) TTL(ttl time.Duration) *toMsgVoice {
+	tm.ttl = ttl
+	return tm
+}
+
+// Send 发送消息
+func (tm *toMsgVoice) Send() error {
+	return tm.SendContext(context.Background())
+}
+
+// SendContext 发送消息 ctx取消或超时后会中断发送，包括Voice(...)指定语音的上传
+func (tm *toMsgVoice) SendContext(ctx context.Context) error {
+	if err := tm.Validate(); err != nil {
+		return err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Voice = tm
+	return cmsg.send(ctx)
+}
+
+// SendAsync 异步发送消息 需先调用bot.Async(...)开启异步模式 返回的AsyncResult可等待发送结果
+func (tm *toMsgVoice) SendAsync() (*AsyncResult, error) {
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+	cmsg := tm.buildCommonMsg()
+	cmsg.Voice = tm
+	return cmsg.sendAsync()
+}
+
+// check 发送前检查是否设置了要发送的语音
+func (tm *toMsgVoice) check() error {
+	if tm.err != nil {
+		return tm.err
+	}
+	if tm.MediaID == "" && tm.fileContent == nil {
+		return fmt.Errorf("请先调用Voice(...)或MediaId(...)方法设置要发送的语音")
+	}
+	return nil
 }
 
 // MsgAttachment markdown附加数据
@@ -373,23 +811,33 @@ type upLoadRes struct {
 	CreatedAt int64  `json:"created_at"`
 }
 
-func (tm *toMsgFile) uploadFile(filename string, pdfContent []byte) (*upLoadRes, error) {
-	bodyBuf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(bodyBuf)
-	part, err := bodyWriter.CreateFormFile("file1", filename)
-	if err != nil {
-		wxRobot.logger.Error(fmt.Sprintf("Cannot CreateFormFile for: %s , err: %v", filename, err))
-		return nil, err
+// upload 上传一次文件 multipart请求体通过io.Pipe边读边发送 不会将整个文件缓存在内存中
+func (r *bot) upload(ctx context.Context, filename string, src io.Reader, mediaType MediaType) (*upLoadRes, error) {
+	if r.dryRun {
+		return r.dryRunUpload(filename, src, mediaType)
 	}
 
-	_, err = part.Write(pdfContent)
-	if err != nil {
-		wxRobot.logger.Error(fmt.Sprintf("Cannot Write file: %s , err: %v", filename, err))
-		return nil, err
-	}
-	_ = bodyWriter.Close()
-	req, err := http.NewRequest("POST", tm.bot.uploadUrl, bodyBuf)
+	pr, pw := io.Pipe()
+	bodyWriter := multipart.NewWriter(pw)
+	go func() {
+		part, err := bodyWriter.CreatePart(mediaPartHeader(filename))
+		if err != nil {
+			wxRobot.logger.Error(fmt.Sprintf("Cannot CreateFormFile for: %s , err: %v", filename, err))
+			_ = pw.CloseWithError(err)
+			return
+		}
+
+		if _, err = io.Copy(part, src); err != nil {
+			wxRobot.logger.Error(fmt.Sprintf("Cannot Write file: %s , err: %v", filename, err))
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_ = pw.CloseWithError(bodyWriter.Close())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.uploadUrl+"&type="+string(mediaType), pr)
 	if err != nil {
+		_ = pr.Close()
 		wxRobot.logger.Error("NewRequest err:", err)
 		return nil, err
 	}
@@ -408,8 +856,12 @@ func (tm *toMsgFile) uploadFile(filename string, pdfContent []byte) (*upLoadRes,
 	}
 
 	uploadRes := &upLoadRes{}
-	_ = json.Unmarshal(body, uploadRes)
-	wxRobot.logger.Debug("uploadRes :", uploadRes)
+	err = parseResponse(resp.StatusCode, body, uploadRes)
+	wxRobot.logger.Debug("uploadRes :", resp.StatusCode, string(body))
+	if err != nil {
+		wxRobot.logger.Error("uploadFile err:", err)
+		return nil, err
+	}
 
 	return uploadRes, nil
 }
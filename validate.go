@@ -0,0 +1,131 @@
+package wxrobot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	MaxChatIds      = 100 // chatid最多支持100个
+	MaxNewsArticles = 8   // 图文消息最多支持8条图文
+)
+
+var ErrInvalidMessage = errors.New("wxrobot: invalid message")
+
+// hexColorPattern 按钮颜色 如2EAB49
+var hexColorPattern = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidMessage, fmt.Sprintf(format, args...))
+}
+
+// validate 校验所有消息类型共有的chatid、visible_to_user及post_id
+func (t *toBaseMsg) validate() error {
+	if len(t.chatids) > MaxChatIds {
+		return invalidf("chatid最多支持%d个, 当前为%d个", MaxChatIds, len(t.chatids))
+	}
+	if len(t.visibleToUsers) > 0 && len(t.chatids) != 1 {
+		return invalidf("Visible(...)有且只有chatid指定了一个群或一个小黑板时生效, 当前chatid为%d个", len(t.chatids))
+	}
+	if t.postId != "" {
+		if len(t.chatids) != 1 {
+			return invalidf("PostId(...)有且只有chatid指定了一个小黑板时生效, 当前chatid为%d个", len(t.chatids))
+		}
+		// 由回调消息创建时已知会话类型
+		if t.chatType != "" && t.chatType != ChatTypeBlackboard && t.chatType != ChatTypeBlackboardReply {
+			return invalidf("PostId(...)仅在小黑板会话中生效, 当前会话类型为%s", t.chatType)
+		}
+	}
+	return nil
+}
+
+// checkContent 校验消息内容不为空且不超过长度限制 开启拆分时不限制长度
+func checkContent(content string, limit int, split bool) error {
+	if content == "" {
+		return invalidf("消息内容不能为空")
+	}
+	if !split && len(content) > limit {
+		return fmt.Errorf("%w: 内容为%d字节, 不能超过%d字节, 可调用Split(...)自动拆分", ErrContentTooLong, len(content), limit)
+	}
+	return nil
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+func (t *toMsgText) Validate() error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	return checkContent(t.Content, MaxTextBytes, t.split)
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+func (tm *toMsgMarkdown) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	if err := checkContent(tm.Content, MaxMarkdownBytes, tm.split); err != nil {
+		return err
+	}
+	if tm.split && len(tm.Content) > MaxMarkdownBytes {
+		if _, err := splitContent(tm.Content, MaxMarkdownBytes, true, tm.splitNumbered); err != nil {
+			return err
+		}
+	}
+
+	for i, attach := range tm.Attachments {
+		if attach == nil {
+			return invalidf("attachments[%d]不能为空", i)
+		}
+		for j, action := range attach.Actions {
+			if err := checkAction(fmt.Sprintf("attachments[%d].actions[%d]", i, j), action); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+func (tm *toMsgNews) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	if len(tm.Articles_) < 1 || len(tm.Articles_) > MaxNewsArticles {
+		return invalidf("图文消息支持1到%d条图文, 当前为%d条", MaxNewsArticles, len(tm.Articles_))
+	}
+	for i, article := range tm.Articles_ {
+		if article == nil || article.Title == "" {
+			return invalidf("articles[%d].title不能为空", i)
+		}
+		if article.URL == "" {
+			return invalidf("articles[%d].url不能为空", i)
+		}
+	}
+	return nil
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+// 开启AutoConvert()时会在校验时完成图片转换
+func (tm *toMsgImage) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	return tm.prepare()
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+func (tm *toMsgFile) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	return tm.check()
+}
+
+// Validate 校验消息是否满足企业微信的要求 Send时会自动调用
+func (tm *toMsgVoice) Validate() error {
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	return tm.check()
+}
@@ -0,0 +1,65 @@
+package wxrobot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MaxVoiceBytes    = 2 << 20          // 语音文件最大不能超过2M
+	MaxVoiceDuration = 60 * time.Second // 语音播放时长不能超过60s
+)
+
+var ErrVoiceFormat = errors.New("wxrobot: voice must be amr format")
+
+// amr文件头及各编码模式的帧长度(字节 含帧头) 每帧20ms 为0表示无效的模式
+var (
+	amrNBMagic      = []byte("#!AMR\n")
+	amrWBMagic      = []byte("#!AMR-WB\n")
+	amrNBFrameSizes = [16]int{13, 14, 16, 18, 20, 21, 27, 32, 6, 0, 0, 0, 0, 0, 0, 1}
+	amrWBFrameSizes = [16]int{18, 24, 33, 37, 41, 47, 51, 59, 61, 6, 0, 0, 0, 0, 1, 1}
+)
+
+const amrFrameDuration = 20 * time.Millisecond
+
+// amrDuration 解析amr文件的播放时长
+func amrDuration(bts []byte) (time.Duration, error) {
+	var sizes *[16]int
+	switch {
+	case bytes.HasPrefix(bts, amrNBMagic):
+		sizes, bts = &amrNBFrameSizes, bts[len(amrNBMagic):]
+	case bytes.HasPrefix(bts, amrWBMagic):
+		sizes, bts = &amrWBFrameSizes, bts[len(amrWBMagic):]
+	default:
+		return 0, ErrVoiceFormat
+	}
+
+	var frames int
+	for len(bts) > 0 {
+		size := sizes[(bts[0]>>3)&0x0f]
+		if size == 0 || size > len(bts) {
+			return 0, fmt.Errorf("%w: 第%d帧数据无效", ErrVoiceFormat, frames+1)
+		}
+		bts = bts[size:]
+		frames++
+	}
+	return time.Duration(frames) * amrFrameDuration, nil
+}
+
+// checkVoice 校验语音文件的格式、大小及时长
+func checkVoice(name string, bts []byte) error {
+	if err := checkMediaSize(name, int64(len(bts)), MediaTypeVoice); err != nil {
+		return err
+	}
+
+	duration, err := amrDuration(bts)
+	if err != nil {
+		return err
+	}
+	if duration > MaxVoiceDuration {
+		return fmt.Errorf("%w: 语音[%s]时长为%v, 不能超过%v", ErrMediaSize, name, duration, MaxVoiceDuration)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package wxrobot
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// amrFrames 生成n个mode模式的帧 帧头为mode<<3|0x04 帧数据填充为0
+func amrFrames(sizes *[16]int, mode byte, n int) []byte {
+	frame := make([]byte, sizes[mode])
+	frame[0] = mode<<3 | 0x04
+	return bytes.Repeat(frame, n)
+}
+
+func amrNB(frames ...[]byte) []byte {
+	return append(append([]byte{}, amrNBMagic...), bytes.Join(frames, nil)...)
+}
+
+func TestAmrDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    time.Duration
+		wantErr error
+	}{
+		{"nb 12.2k", amrNB(amrFrames(&amrNBFrameSizes, 7, 50)), time.Second, nil},
+		{"nb mixed modes", amrNB(amrFrames(&amrNBFrameSizes, 0, 10), amrFrames(&amrNBFrameSizes, 8, 5), amrFrames(&amrNBFrameSizes, 15, 5)), 400 * time.Millisecond, nil},
+		{"wb", append(append([]byte{}, amrWBMagic...), amrFrames(&amrWBFrameSizes, 2, 10)...), 200 * time.Millisecond, nil},
+		{"header only", amrNB(), 0, nil},
+		{"invalid mode", amrNB(amrFrames(&amrNBFrameSizes, 7, 2), []byte{9 << 3}), 0, ErrVoiceFormat},
+		{"truncated frame", amrNB(amrFrames(&amrNBFrameSizes, 7, 2))[:len(amrNBMagic)+40], 0, ErrVoiceFormat},
+		{"not amr", []byte("ID3\x03\x00\x00\x00"), 0, ErrVoiceFormat},
+		{"empty", nil, 0, ErrVoiceFormat},
+	}
+	for _, tt := range tests {
+		got, err := amrDuration(tt.data)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: duration = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVoice(t *testing.T) {
+	// 每秒50帧
+	if err := checkVoice("ok.amr", amrNB(amrFrames(&amrNBFrameSizes, 0, 60*50))); err != nil {
+		t.Errorf("60s voice: %v", err)
+	}
+	if err := checkVoice("long.amr", amrNB(amrFrames(&amrNBFrameSizes, 0, 60*50+1))); !errors.Is(err, ErrMediaSize) {
+		t.Errorf("voice over 60s: err = %v, want ErrMediaSize", err)
+	}
+	if err := checkVoice("tiny.amr", []byte("#!")); !errors.Is(err, ErrMediaSize) {
+		t.Errorf("tiny voice: err = %v, want ErrMediaSize", err)
+	}
+	if err := checkVoice("song.mp3", []byte("ID3\x03\x00\x00\x00\x00")); !errors.Is(err, ErrVoiceFormat) {
+		t.Errorf("mp3 voice: err = %v, want ErrVoiceFormat", err)
+	}
+}
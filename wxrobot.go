@@ -33,16 +33,35 @@ type bot struct {
 	aesKey     string // 接入验证的encodingAesKey
 	receiverId string
 	webhookURL string // 主动推送消息的地址
-	uploadUrl  string // 上传文件地址
+	uploadUrl  string // 上传文件地址 不含type参数
 	debug      bool
 	router     *mux.Router
 	msgCrypt   *WXBizMsgCrypt
 
+	stateSecret []byte // 按钮状态的签名密钥
+
+	retryPolicy *RetryPolicy // 发送消息及上传文件的重试策略 为nil时不重试
+	limiter     *rateLimiter // 客户端限流 为nil时不限流
+	queue       *asyncQueue  // 异步发送队列 为nil时同步发送 通过currentQueue()读取
+	queueMu     sync.RWMutex // 保护queue
+	outbox      *outbox      // 待发送消息的持久化目录 为nil时不持久化
+	mediaCache  mediaCache   // 上传过的media_id缓存
+	templates   templateSet  // 注册的消息模版
+	sched       scheduler    // 定时任务
+	delay       delayQueue   // 延迟发送的消息
+	idempotency *idempotency // 消息去重配置 为nil时使用默认配置
+	sessions    *sessions    // 会话存储 为nil时使用默认配置
+
+	dryRun         bool                 // dry-run模式下不发起网络请求
+	dryRunRecorder func(payload []byte) // dry-run模式下记录发送的消息
+
 	eventHandler      eventHandler
 	textHandler       textHandler
 	imageHandler      imageHandler
 	attachmentHandler attachmentHandler
 	mixedHandler      mixedHandler
+	buttons           buttonRouter  // 按CallbackID及按钮名字注册的点击事件处理函数
+	commands          commandRouter // 按名字注册的文本命令
 }
 
 // Bot 新建或获取一个机器人
@@ -65,7 +84,7 @@ func HttpClient(client *http.Client) *wxBot {
 // WebhookURL 设置机器人的WebhookURL 用于推送消息
 func (r *bot) WebhookURL(url string) *bot {
 	r.webhookURL = url
-	r.uploadUrl = strings.ReplaceAll(url, "send", "upload_media") + "&type=file"
+	r.uploadUrl = strings.ReplaceAll(url, "send", "upload_media")
 	return r
 }
 
@@ -134,6 +153,15 @@ func (r *bot) ToMarkdownMsg(markdown string) *toMsgMarkdown {
 	return t
 }
 
+// ToMarkdownV2Msg 新建要发送的markdown_v2消息 支持表格、代码块、列表及图片
+func (r *bot) ToMarkdownV2Msg(markdown string) *toMsgMarkdownV2 {
+	t := new(toMsgMarkdownV2)
+	t.bot = r
+	t.msgType = "markdown_v2"
+	t.Content = markdown
+	return t
+}
+
 // ToImageMsg 新建要发送的图片消息
 func (r *bot) ToImageMsg() *toMsgImage {
 	t := new(toMsgImage)
@@ -157,3 +185,20 @@ func (r *bot) ToFileMsg() *toMsgFile {
 	t.msgType = "file"
 	return t
 }
+
+// ToVoiceMsg 新建要发送的语音消息
+func (r *bot) ToVoiceMsg() *toMsgVoice {
+	t := new(toMsgVoice)
+	t.bot = r
+	t.msgType = "voice"
+	return t
+}
+
+// ToTemplateCardMsg 新建要发送的模版卡片消息 cardType为TemplateCardTextNotice或TemplateCardNewsNotice
+func (r *bot) ToTemplateCardMsg(cardType TemplateCardType) *toMsgTemplateCard {
+	t := new(toMsgTemplateCard)
+	t.bot = r
+	t.msgType = "template_card"
+	t.CardType = cardType
+	return t
+}